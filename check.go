@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/lomik/prometheus-png/pkg"
+)
+
+type configProblem struct {
+	line int
+	msg  string
+}
+
+var (
+	tableRe = regexp.MustCompile(`^\s*\[\[?\s*([^\]]+?)\s*\]\]?`)
+	keyRe   = regexp.MustCompile(`^\s*("[^"]*"|'[^']*'|[A-Za-z0-9_-]+)\s*=`)
+)
+
+// configKeyLines maps full dotted key names to line numbers. BurntSushi/toml doesn't keep key positions
+func configKeyLines(body string) map[string]int {
+	lines := make(map[string]int)
+	table := ""
+	for i, line := range strings.Split(body, "\n") {
+		if m := tableRe.FindStringSubmatch(line); m != nil {
+			parts := strings.Split(m[1], ".")
+			for j := range parts {
+				parts[j] = strings.Trim(strings.TrimSpace(parts[j]), `"'`)
+			}
+			table = strings.Join(parts, ".")
+			if _, exists := lines[table]; !exists {
+				lines[table] = i + 1
+			}
+			continue
+		}
+		if m := keyRe.FindStringSubmatch(line); m != nil {
+			key := strings.Trim(m[1], `"'`)
+			if table != "" {
+				key = table + "." + key
+			}
+			lines[key] = i + 1
+		}
+	}
+	return lines
+}
+
+// checkConfig validates config file. Returned problems are sorted by line
+func checkConfig(filename string, timeout time.Duration) []configProblem {
+	var problems []configProblem
+
+	configBody, err := readConfig(filename)
+	if err != nil {
+		return []configProblem{{msg: err.Error()}}
+	}
+	lines := configKeyLines(configBody)
+
+	config := newConfig()
+	md, err := toml.Decode(configBody, config)
+	if err != nil {
+		// parse errors contain line number
+		return []configProblem{{msg: err.Error()}}
+	}
+
+	undecoded := make(map[string]bool)
+	for _, key := range md.Undecoded() {
+		undecoded[key.String()] = true
+	}
+	for _, key := range md.Undecoded() {
+		// report only topmost unknown table
+		if len(key) > 1 && undecoded[toml.Key(key[:len(key)-1]).String()] {
+			continue
+		}
+		problems = append(problems, configProblem{lines[key.String()], fmt.Sprintf("unknown key %#v", key.String())})
+	}
+
+	for _, d := range config.durations() {
+		if *d.value, err = time.ParseDuration(d.raw); err != nil {
+			problems = append(problems, configProblem{lines[d.key], fmt.Sprintf("%s: %s", d.key, err)})
+		}
+	}
+
+	templates, err := config.templates()
+	if err != nil {
+		problems = append(problems, configProblem{msg: err.Error()})
+	}
+	for templateName, values := range templates {
+		for name, err := range pkg.CheckTemplateColors(values) {
+			key := fmt.Sprintf("template.%s.%s", templateName, name)
+			problems = append(problems, configProblem{lines[key], fmt.Sprintf("%s: %s", key, err)})
+		}
+	}
+
+	policies, err := config.policies()
+	if err != nil {
+		problems = append(problems, configProblem{msg: err.Error()})
+	}
+	if _, err := config.apiKeys(policies); err != nil {
+		problems = append(problems, configProblem{msg: err.Error()})
+	}
+	datasources, _, err := config.datasources()
+	if err != nil {
+		problems = append(problems, configProblem{msg: err.Error()})
+	}
+	if config.Main.StaticDir != "" {
+		if info, err := os.Stat(config.Main.StaticDir); err != nil {
+			problems = append(problems, configProblem{lines["main.static-dir"], fmt.Sprintf("main.static-dir: %s", err)})
+		} else if !info.IsDir() {
+			problems = append(problems, configProblem{lines["main.static-dir"], fmt.Sprintf("main.static-dir: %s is not a directory", config.Main.StaticDir)})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	prom := pkg.NewPNG(config.Main.PrometheusAddr, config.Main.PrometheusPath, config.Main.Timeout)
+	if err := prom.Ping(ctx); err != nil {
+		problems = append(problems, configProblem{lines["main.prometheus-addr"], fmt.Sprintf("prometheus %s is unreachable: %s", config.Main.PrometheusAddr, err)})
+	}
+	for name, ds := range datasources {
+		p, ok := ds.(interface {
+			Ping(ctx context.Context) error
+		})
+		if !ok {
+			continue
+		}
+		if err := p.Ping(ctx); err != nil {
+			key := "datasource." + name
+			problems = append(problems, configProblem{lines[key], fmt.Sprintf("%s: %s is unreachable: %s", key, config.Datasource[name].URL, err)})
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].line < problems[j].line
+	})
+	return problems
+}
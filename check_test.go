@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigKeyLines(t *testing.T) {
+	assert := assert.New(t)
+
+	lines := configKeyLines(`listen = ":8080"
+[main]
+prometheus-addr = "http://127.0.0.1:9090"
+  "timeout" = "1m"
+
+[ template . "light" ]
+bgcolor = "white"
+[[alerts]]
+name = "a"
+`)
+	assert.Equal(map[string]int{
+		"listen":                 1,
+		"main":                   2,
+		"main.prometheus-addr":   3,
+		"main.timeout":           4,
+		"template.light":         6,
+		"template.light.bgcolor": 7,
+		"alerts":                 8,
+		"alerts.name":            9,
+	}, lines)
+}
+
+func TestCheckConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/-/ready", r.URL.Path)
+	}))
+	defer prom.Close()
+
+	dir, err := ioutil.TempDir("", "prometheus-png")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "config.toml")
+
+	write := func(body string) {
+		assert.NoError(ioutil.WriteFile(filename, []byte(body), 0644))
+	}
+
+	write(`[main]
+prometheus-addr = "` + prom.URL + `"
+`)
+	assert.Empty(checkConfig(filename, time.Second))
+
+	write(`[main]
+prometheus-addr = "` + prom.URL + `"
+timeout = "10x"
+unknown-key = 1
+
+[unknown-table]
+a = 1
+b = 2
+
+[template.light]
+bgcolor = "nocolor"
+fgcolor = "steelblue"
+`)
+	problems := checkConfig(filename, time.Second)
+	if assert.Len(problems, 4) {
+		assert.Equal(3, problems[0].line)
+		assert.Contains(problems[0].msg, "main.timeout: ")
+		assert.Equal(configProblem{4, `unknown key "main.unknown-key"`}, problems[1])
+		assert.Equal(configProblem{6, `unknown key "unknown-table"`}, problems[2])
+		assert.Equal(configProblem{11, `template.light.bgcolor: invalid color "nocolor"`}, problems[3])
+	}
+
+	write(`[main]
+prometheus-addr = "` + prom.URL + `"
+
+[datasource.replica]
+type = "prometheus"
+url = "` + prom.URL + `"
+
+[datasource.graphite]
+type = "graphite"
+url = "http://127.0.0.1:1"
+`)
+	problems = checkConfig(filename, time.Second)
+	if assert.Len(problems, 1) {
+		assert.Equal(8, problems[0].line)
+		assert.Contains(problems[0].msg, "datasource.graphite: http://127.0.0.1:1 is unreachable: ")
+	}
+
+	problems = checkConfig(filepath.Join(dir, "missing.toml"), time.Second)
+	if assert.Len(problems, 1) {
+		assert.Equal(0, problems[0].line)
+	}
+}
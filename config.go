@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/lomik/prometheus-png/pkg"
+)
+
+var defaultPictureParams = map[string]interface{}{
+	"colorList":          "7EB26D,EAB839,6ED0E0,EF843C,E24D42,1F78C1,BA43A9,705DA0,508642,CCA300,447EBC,C15C17,890F02,0A437C,6D1F62,584477,B7DBAB,F4D598,70DBED,F9BA8F,F29191,82B5D8,E5A8E2,AEA2E0,629E51,E5AC0E,64B0C8,E0752D,BF1B00,0A50A1,962D82,614D93,9AC48A,F2C96D,65C5DB,F9934E,EA6460,5195CE,D683CE,806EB7,3F6833,967302,2F575E,99440A,58140C,052B51,511749,3F2B5B,E0F9D7,FCEACA,CFFAFF,F9E2D2,FCE2DE,BADFF4,F9D9F9,DEDAF7",
+	"areaMode":           "all",
+	"majorGridLineColor": "666666",
+	"minorGridLineColor": "666666",
+	"bgcolor":            "171819",
+	"areaAlpha":          "0.09",
+	"fontName":           "Roboto",
+}
+
+type MainConfig struct {
+	Listen             string        `toml:"listen"`
+	PrometheusAddr     string        `toml:"prometheus-addr"`
+	PrometheusPath     string        `toml:"prometheus-path"`
+	TimeoutRaw         string        `toml:"timeout"`
+	Timeout            time.Duration `toml:"-"`
+	Renderer           string        `toml:"renderer"`
+	ReadTimeoutRaw     string        `toml:"read-timeout"`
+	ReadTimeout        time.Duration `toml:"-"`
+	WriteTimeoutRaw    string        `toml:"write-timeout"`
+	WriteTimeout       time.Duration `toml:"-"`
+	IdleTimeoutRaw     string        `toml:"idle-timeout"`
+	IdleTimeout        time.Duration `toml:"-"`
+	ShutdownTimeoutRaw string        `toml:"shutdown-timeout"`
+	ShutdownTimeout    time.Duration `toml:"-"`
+	TLSCertFile        string        `toml:"tls-cert-file"`
+	TLSKeyFile         string        `toml:"tls-key-file"`
+	ReloadIntervalRaw  string        `toml:"reload-interval"`
+	ReloadInterval     time.Duration `toml:"-"`
+	ReloadEndpoint     bool          `toml:"enable-reload-endpoint"`
+	StaticDir          string        `toml:"static-dir"`
+	MinStepRaw         string        `toml:"min-step"`
+	MinStep            time.Duration `toml:"-"`
+}
+
+type AlertmanagerConfig struct {
+	NotifyURL    string            `toml:"notify-url"`
+	FileField    string            `toml:"file-field"`
+	CaptionField string            `toml:"caption-field"`
+	Fields       map[string]string `toml:"fields"`
+	Template     string            `toml:"template"`
+	BeforeRaw    string            `toml:"before"`
+	Before       time.Duration     `toml:"-"`
+	AfterRaw     string            `toml:"after"`
+	After        time.Duration     `toml:"-"`
+	TimeoutRaw   string            `toml:"timeout"`
+	Timeout      time.Duration     `toml:"-"`
+	Token        string            `toml:"token"`
+}
+
+type CacheConfig struct {
+	Size     int64         `toml:"size"`
+	TTLRaw   string        `toml:"ttl"`
+	TTL      time.Duration `toml:"-"`
+	Dir      string        `toml:"dir"`
+	DiskSize int64         `toml:"disk-size"`
+}
+
+type AccessLogConfig struct {
+	File             string        `toml:"file"`
+	Sample           float64       `toml:"sample"`
+	SlowThresholdRaw string        `toml:"slow-threshold"`
+	SlowThreshold    time.Duration `toml:"-"`
+}
+
+type SignConfig struct {
+	Secret  string        `toml:"secret"`
+	Require bool          `toml:"require"`
+	TTLRaw  string        `toml:"ttl"`
+	TTL     time.Duration `toml:"-"`
+}
+
+type PolicyConfig struct {
+	MetricAllow      []string          `toml:"metric-allow"`
+	MetricDeny       []string          `toml:"metric-deny"`
+	MaxRange         string            `toml:"max-range"`
+	DenyFunctions    []string          `toml:"deny-functions"`
+	RequiredMatchers map[string]string `toml:"required-matchers"`
+	DatasourceAllow  []string          `toml:"datasource-allow"`
+}
+
+type APIKeyConfig struct {
+	Key         string   `toml:"key"`
+	Templates   []string `toml:"templates"`
+	Datasources []string `toml:"datasources"`
+	Policy      string   `toml:"policy"`
+	Rate        float64  `toml:"rate"`
+	Burst       int      `toml:"burst"`
+}
+
+type DatasourceConfig struct {
+	Type string `toml:"type"`
+	URL  string `toml:"url"`
+	// query_range path of prometheus datasource
+	Path string `toml:"path"`
+	// lower bound of query step, usually scrape interval
+	MinStep string `toml:"min-step"`
+	// limit of decoded remote-read response, bytes
+	MaxResponseSize int `toml:"max-response-size"`
+}
+
+type AuthConfig struct {
+	Header string `toml:"header"`
+}
+
+type LimitConfig struct {
+	FetchConcurrency  int           `toml:"fetch-concurrency"`
+	RenderConcurrency int           `toml:"render-concurrency"`
+	QueueSize         int           `toml:"queue-size"`
+	QueueTimeoutRaw   string        `toml:"queue-timeout"`
+	QueueTimeout      time.Duration `toml:"-"`
+}
+
+type Config struct {
+	Main         MainConfig                          `toml:"main"`
+	AccessLog    AccessLogConfig                     `toml:"access-log"`
+	Cache        CacheConfig                         `toml:"cache"`
+	Limit        LimitConfig                         `toml:"limit"`
+	Sign         SignConfig                          `toml:"sign"`
+	Policy       map[string]PolicyConfig             `toml:"policy"`
+	Auth         AuthConfig                          `toml:"auth"`
+	APIKey       map[string]APIKeyConfig             `toml:"api-key"`
+	Datasource   map[string]DatasourceConfig         `toml:"datasource"`
+	Alertmanager AlertmanagerConfig                  `toml:"alertmanager"`
+	Template     map[string](map[string]interface{}) `toml:"template"`
+}
+
+func newConfig() *Config {
+	return &Config{
+		Main: MainConfig{
+			PrometheusAddr:     "http://127.0.0.1:9090",
+			PrometheusPath:     "/api/v1/query_range",
+			Listen:             ":8080",
+			Timeout:            10 * time.Second,
+			TimeoutRaw:         "10s",
+			ReadTimeout:        10 * time.Second,
+			ReadTimeoutRaw:     "10s",
+			WriteTimeout:       time.Minute,
+			WriteTimeoutRaw:    "1m",
+			IdleTimeout:        2 * time.Minute,
+			IdleTimeoutRaw:     "2m",
+			ShutdownTimeout:    30 * time.Second,
+			ShutdownTimeoutRaw: "30s",
+			ReloadIntervalRaw:  "0s",
+			MinStepRaw:         "0s",
+		},
+		AccessLog: AccessLogConfig{
+			Sample:           1,
+			SlowThreshold:    5 * time.Second,
+			SlowThresholdRaw: "5s",
+		},
+		Cache: CacheConfig{
+			TTL:      time.Minute,
+			TTLRaw:   "1m",
+			DiskSize: 1 << 30,
+		},
+		Limit: LimitConfig{
+			QueueSize:       100,
+			QueueTimeout:    5 * time.Second,
+			QueueTimeoutRaw: "5s",
+		},
+		Auth: AuthConfig{
+			Header: "X-API-Key",
+		},
+		Sign: SignConfig{
+			TTL:    24 * time.Hour,
+			TTLRaw: "24h",
+		},
+		Alertmanager: AlertmanagerConfig{
+			FileField:    "photo",
+			CaptionField: "caption",
+			Before:       time.Hour,
+			BeforeRaw:    "1h",
+			After:        15 * time.Minute,
+			AfterRaw:     "15m",
+			Timeout:      30 * time.Second,
+			TimeoutRaw:   "30s",
+		},
+	}
+}
+
+var envRe = regexp.MustCompile(`\$\{ENV:([a-zA-Z0-9_]+)\}`)
+
+// readConfig returns config file body with "${ENV:VARIABLE_NAME}" macros replaced
+func readConfig(filename string) (string, error) {
+	configBodyBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	return envRe.ReplaceAllStringFunc(string(configBodyBytes), func(m string) string {
+		parts := envRe.FindStringSubmatch(m)
+		return os.Getenv(parts[1])
+	}), nil
+}
+
+type configDuration struct {
+	key   string
+	raw   string
+	value *time.Duration
+}
+
+func (config *Config) durations() []configDuration {
+	return []configDuration{
+		{"main.timeout", config.Main.TimeoutRaw, &config.Main.Timeout},
+		{"main.read-timeout", config.Main.ReadTimeoutRaw, &config.Main.ReadTimeout},
+		{"main.write-timeout", config.Main.WriteTimeoutRaw, &config.Main.WriteTimeout},
+		{"main.idle-timeout", config.Main.IdleTimeoutRaw, &config.Main.IdleTimeout},
+		{"main.shutdown-timeout", config.Main.ShutdownTimeoutRaw, &config.Main.ShutdownTimeout},
+		{"main.reload-interval", config.Main.ReloadIntervalRaw, &config.Main.ReloadInterval},
+		{"main.min-step", config.Main.MinStepRaw, &config.Main.MinStep},
+		{"access-log.slow-threshold", config.AccessLog.SlowThresholdRaw, &config.AccessLog.SlowThreshold},
+		{"cache.ttl", config.Cache.TTLRaw, &config.Cache.TTL},
+		{"limit.queue-timeout", config.Limit.QueueTimeoutRaw, &config.Limit.QueueTimeout},
+		{"sign.ttl", config.Sign.TTLRaw, &config.Sign.TTL},
+		{"alertmanager.before", config.Alertmanager.BeforeRaw, &config.Alertmanager.Before},
+		{"alertmanager.after", config.Alertmanager.AfterRaw, &config.Alertmanager.After},
+		{"alertmanager.timeout", config.Alertmanager.TimeoutRaw, &config.Alertmanager.Timeout},
+	}
+}
+
+// loadConfig reads config file over defaults. Empty filename returns defaults
+func loadConfig(filename string) (*Config, error) {
+	config := newConfig()
+	if filename == "" {
+		return config, nil
+	}
+
+	configBody, err := readConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := toml.Decode(configBody, config); err != nil {
+		return nil, err
+	}
+
+	for _, d := range config.durations() {
+		if *d.value, err = time.ParseDuration(d.raw); err != nil {
+			return nil, fmt.Errorf("%s: %s", d.key, err)
+		}
+	}
+
+	if config.Sign.Require && config.Sign.Secret == "" {
+		return nil, fmt.Errorf("sign.require is set but sign.secret is empty")
+	}
+	// webhook queries arbitrary expressions, it can't stay open when pictures are protected
+	if config.Sign.Require && config.Alertmanager.NotifyURL != "" && config.Alertmanager.Token == "" {
+		return nil, fmt.Errorf("sign.require is set but alertmanager.token is empty")
+	}
+
+	return config, nil
+}
+
+// policies returns query policies by name
+func (config *Config) policies() (map[string]*pkg.Policy, error) {
+	result := make(map[string]*pkg.Policy)
+	for name, p := range config.Policy {
+		options := pkg.PolicyOptions{
+			MetricAllow:      p.MetricAllow,
+			MetricDeny:       p.MetricDeny,
+			DenyFunctions:    p.DenyFunctions,
+			RequiredMatchers: p.RequiredMatchers,
+			DatasourceAllow:  p.DatasourceAllow,
+		}
+		if p.MaxRange != "" {
+			d, err := time.ParseDuration(p.MaxRange)
+			if err != nil {
+				return nil, fmt.Errorf("policy.%s.max-range: %s", name, err)
+			}
+			options.MaxRange = d
+		}
+		policy, err := pkg.NewPolicy(options)
+		if err != nil {
+			return nil, fmt.Errorf("policy.%s: %s", name, err)
+		}
+		result[name] = policy
+	}
+	return result, nil
+}
+
+// apiKeys returns nil if no keys configured
+func (config *Config) apiKeys(policies map[string]*pkg.Policy) (*pkg.APIKeys, error) {
+	if len(config.APIKey) == 0 {
+		return nil, nil
+	}
+	var options []pkg.APIKeyOptions
+	for name, k := range config.APIKey {
+		if k.Key == "" {
+			return nil, fmt.Errorf("api-key.%s.key is empty", name)
+		}
+		o := pkg.APIKeyOptions{
+			Name:        name,
+			Key:         k.Key,
+			Templates:   k.Templates,
+			Datasources: k.Datasources,
+			Rate:        k.Rate,
+			Burst:       k.Burst,
+		}
+		if k.Policy != "" {
+			policy, exists := policies[k.Policy]
+			if !exists {
+				return nil, fmt.Errorf("api-key.%s.policy: unknown policy %#v", name, k.Policy)
+			}
+			o.Policy = policy
+		}
+		options = append(options, o)
+	}
+	return pkg.NewAPIKeys(config.Auth.Header, options), nil
+}
+
+// datasources returns additional datasources and min steps by name
+func (config *Config) datasources() (map[string]pkg.Datasource, map[string]time.Duration, error) {
+	result := make(map[string]pkg.Datasource)
+	minSteps := map[string]time.Duration{"default": config.Main.MinStep}
+	for name, ds := range config.Datasource {
+		if name == "default" || name == "static" {
+			return nil, nil, fmt.Errorf("datasource.%s: name is reserved", name)
+		}
+		if ds.URL == "" {
+			return nil, nil, fmt.Errorf("datasource.%s.url is empty", name)
+		}
+		if ds.MinStep != "" {
+			d, err := time.ParseDuration(ds.MinStep)
+			if err != nil {
+				return nil, nil, fmt.Errorf("datasource.%s.min-step: %s", name, err)
+			}
+			minSteps[name] = d
+		}
+		switch ds.Type {
+		case "prometheus":
+			path := ds.Path
+			if path == "" {
+				path = "/api/v1/query_range"
+			}
+			result[name] = pkg.NewPrometheus(ds.URL, path)
+		case "remote-read":
+			result[name] = pkg.NewRemoteRead(ds.URL, ds.MaxResponseSize)
+		case "graphite":
+			result[name] = pkg.NewGraphite(ds.URL)
+		default:
+			return nil, nil, fmt.Errorf("datasource.%s.type: unknown type %#v", name, ds.Type)
+		}
+	}
+	result["static"] = pkg.NewStatic(config.Main.StaticDir)
+	return result, minSteps, nil
+}
+
+// handlerOptions builds reloadable part of handler configuration
+func (config *Config) handlerOptions() (pkg.HandlerOptions, error) {
+	var o pkg.HandlerOptions
+	var err error
+
+	if o.Templates, err = config.templates(); err != nil {
+		return o, err
+	}
+	policies, err := config.policies()
+	if err != nil {
+		return o, err
+	}
+	o.Policy = policies["default"]
+	if o.APIKeys, err = config.apiKeys(policies); err != nil {
+		return o, err
+	}
+	if o.Datasources, o.MinSteps, err = config.datasources(); err != nil {
+		return o, err
+	}
+	if config.Sign.Secret != "" {
+		o.Signer = pkg.NewSigner(config.Sign.Secret, config.Sign.Require)
+	}
+	return o, nil
+}
+
+// templates returns picture parameters by template name. "default" is merged with defaultPictureParams.
+// Template with "extends" key inherits parameters of parent template
+func (config *Config) templates() (map[string]url.Values, error) {
+	defaultTemplate := make(map[string]interface{})
+	for k, v := range defaultPictureParams {
+		defaultTemplate[k] = v
+	}
+	for k, v := range config.Template["default"] {
+		defaultTemplate[k] = v
+	}
+
+	result := map[string]url.Values{}
+
+	var resolve func(name string, chain []string) (url.Values, error)
+	resolve = func(name string, chain []string) (url.Values, error) {
+		if values, exists := result[name]; exists {
+			return values, nil
+		}
+		for _, c := range chain {
+			if c == name {
+				return nil, fmt.Errorf("template.%s: extends loop %s", chain[0], strings.Join(append(chain, name), " -> "))
+			}
+		}
+		templateData, exists := config.Template[name]
+		if !exists {
+			return nil, fmt.Errorf("template.%s: extends unknown template %#v", chain[len(chain)-1], name)
+		}
+
+		values := url.Values{}
+		if parent, ok := templateData["extends"]; ok {
+			parentName, ok := parent.(string)
+			if !ok {
+				return nil, fmt.Errorf("template.%s.extends: string expected", name)
+			}
+			// all templates inherit default at request time
+			if parentName != "default" {
+				parentValues, err := resolve(parentName, append(chain, name))
+				if err != nil {
+					return nil, err
+				}
+				for k, v := range parentValues {
+					values[k] = v
+				}
+			}
+		}
+		for k, v := range templateData {
+			if k == "extends" {
+				continue
+			}
+			values.Set(k, fmt.Sprint(v))
+		}
+		result[name] = values
+		return values, nil
+	}
+
+	for templateName := range config.Template {
+		if templateName == "default" {
+			continue
+		}
+		if _, err := resolve(templateName, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	values := url.Values{}
+	for k, v := range defaultTemplate {
+		values.Set(k, fmt.Sprint(v))
+	}
+	result["default"] = values
+
+	return result, nil
+}
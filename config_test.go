@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplatesExtends(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		config string
+		err    string
+		// template name -> parameter -> value
+		values map[string]map[string]string
+	}{
+		{
+			config: `
+[template.base]
+bgcolor = "black"
+fgcolor = "white"
+width = 330
+
+[template.dark]
+extends = "base"
+fgcolor = "gray"
+
+[template.wide]
+extends = "dark"
+width = 800`,
+			values: map[string]map[string]string{
+				"base": {"bgcolor": "black", "fgcolor": "white", "width": "330"},
+				"dark": {"bgcolor": "black", "fgcolor": "gray", "width": "330"},
+				"wide": {"bgcolor": "black", "fgcolor": "gray", "width": "800"},
+			},
+		},
+		{
+			// default is inherited at request time
+			config: `
+[template.default]
+bgcolor = "white"
+
+[template.light]
+extends = "default"
+fgcolor = "black"`,
+			values: map[string]map[string]string{
+				"light":   {"fgcolor": "black"},
+				"default": {"bgcolor": "white"},
+			},
+		},
+		{
+			config: `
+[template.a]
+extends = "b"
+
+[template.b]
+extends = "c"
+
+[template.c]
+extends = "a"`,
+			err: "extends loop",
+		},
+		{
+			config: `
+[template.a]
+extends = "a"`,
+			err: "template.a: extends loop a -> a",
+		},
+		{
+			config: `
+[template.a]
+extends = "missing"`,
+			err: `template.a: extends unknown template "missing"`,
+		},
+		{
+			config: `
+[template.a]
+extends = 1`,
+			err: "template.a.extends: string expected",
+		},
+	}
+
+	for _, c := range table {
+		config := newConfig()
+		_, err := toml.Decode(c.config, config)
+		assert.NoError(err, c.config)
+
+		templates, err := config.templates()
+		if c.err != "" {
+			if assert.Error(err, c.config) {
+				assert.Contains(err.Error(), c.err)
+			}
+			continue
+		}
+		assert.NoError(err, c.config)
+		for name, values := range c.values {
+			if assert.Contains(templates, name, c.config) {
+				for k, v := range values {
+					assert.Equal(v, templates[name].Get(k), "%s.%s", name, k)
+				}
+				if name != "default" {
+					assert.Len(templates[name], len(values), name)
+				}
+			}
+		}
+	}
+}
@@ -1,66 +1,50 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/http/httptest"
 	"net/url"
 	"os"
-	"regexp"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
-	"github.com/go-graphite/carbonapi/expr/functions/cairo/png"
 	"github.com/lomik/prometheus-png/pkg"
 )
 
-var defaultPictureParams = map[string]interface{}{
-	"colorList":          "7EB26D,EAB839,6ED0E0,EF843C,E24D42,1F78C1,BA43A9,705DA0,508642,CCA300,447EBC,C15C17,890F02,0A437C,6D1F62,584477,B7DBAB,F4D598,70DBED,F9BA8F,F29191,82B5D8,E5A8E2,AEA2E0,629E51,E5AC0E,64B0C8,E0752D,BF1B00,0A50A1,962D82,614D93,9AC48A,F2C96D,65C5DB,F9934E,EA6460,5195CE,D683CE,806EB7,3F6833,967302,2F575E,99440A,58140C,052B51,511749,3F2B5B,E0F9D7,FCEACA,CFFAFF,F9E2D2,FCE2DE,BADFF4,F9D9F9,DEDAF7",
-	"areaMode":           "all",
-	"majorGridLineColor": "666666",
-	"minorGridLineColor": "666666",
-	"bgcolor":            "171819",
-	"areaAlpha":          "0.09",
-	"fontName":           "Roboto",
-}
-
-type MainConfig struct {
-	Listen         string        `toml:"listen"`
-	PrometheusAddr string        `toml:"prometheus-addr"`
-	PrometheusPath string        `toml:"prometheus-path"`
-	TimeoutRaw     string        `toml:"timeout"`
-	Timeout        time.Duration `toml:"-"`
-}
-
-type Config struct {
-	Main     MainConfig                          `toml:"main"`
-	Template map[string](map[string]interface{}) `toml:"template"`
-}
+// set by -ldflags on build
+var (
+	version  = "dev"
+	revision = ""
+)
 
 func main() {
-	config := Config{
-		Main: MainConfig{
-			PrometheusAddr: "http://127.0.0.1:9090",
-			PrometheusPath: "/api/v1/query_range",
-			Listen:         ":8080",
-			Timeout:        10 * time.Second,
-			TimeoutRaw:     "10s",
-		},
-	}
+	config := newConfig()
 	configFilename := flag.String("config", "", "Config filename. Only TOML format is supported")
 	prom := flag.String("prometheus", config.Main.PrometheusAddr, "Prometheus addr")
 	promPath := flag.String("prometheus.path", config.Main.PrometheusPath, "Path to query_range endpoint")
 	listen := flag.String("listen", config.Main.Listen, "Listen addr")
 	defaultTimeout := flag.Duration("timeout", config.Main.Timeout, "Default timeout for queries")
+	renderer := flag.String("renderer", config.Main.Renderer, "Renderer: cairo or go. Best available by default")
 	configPrintDefault := flag.Bool("config-print-default", false, "Print default config")
+	printVersion := flag.Bool("version", false, "Print version")
+	signURL := flag.String("sign", "", "Print signed url and exit. Secret is sign.secret from config")
+	signTTL := flag.Duration("sign-ttl", config.Sign.TTL, "Lifetime of url signed with -sign. 0 - never expires")
+	checkConfigFlag := flag.Bool("check-config", false, "Check config file and exit. Exit code is non-zero if config is invalid")
 
 	flag.Parse()
 	flagset := make(map[string]bool)
 	flag.Visit(func(f *flag.Flag) { flagset[f.Name] = true })
 
+	if *printVersion {
+		fmt.Println(version, revision)
+		return
+	}
+
 	if *configPrintDefault {
 		enc := toml.NewEncoder(os.Stdout)
 		enc.Indent = ""
@@ -76,23 +60,28 @@ func main() {
 		return
 	}
 
-	if *configFilename != "" {
-		configBodyBytes, err := ioutil.ReadFile(*configFilename)
-		if err != nil {
-			log.Fatal(err)
+	if *checkConfigFlag {
+		if *configFilename == "" {
+			log.Fatal("-config is required for -check-config")
 		}
-
-		configBody := string(configBodyBytes)
-
-		tmplRe := regexp.MustCompile(`\$\{ENV:([a-zA-Z0-9_]+)\}`)
-		configBody = tmplRe.ReplaceAllStringFunc(configBody, func(m string) string {
-			parts := tmplRe.FindStringSubmatch(m)
-			return os.Getenv(parts[1])
-		})
-
-		if _, err := toml.Decode(configBody, &config); err != nil {
-			log.Fatal(err)
+		problems := checkConfig(*configFilename, 5*time.Second)
+		for _, p := range problems {
+			if p.line > 0 {
+				fmt.Fprintf(os.Stderr, "%s:%d: %s\n", *configFilename, p.line, p.msg)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", *configFilename, p.msg)
+			}
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
 		}
+		fmt.Printf("%s: OK\n", *configFilename)
+		return
+	}
+
+	config, err := loadConfig(*configFilename)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	if flagset["prometheus"] {
@@ -107,38 +96,131 @@ func main() {
 	if flagset["timeout"] {
 		config.Main.Timeout = *defaultTimeout
 	}
-
-	if config.Template == nil {
-		config.Template = make(map[string](map[string]interface{}))
+	if flagset["renderer"] {
+		config.Main.Renderer = *renderer
 	}
-	if p, exists := config.Template["default"]; exists {
-		for k, v := range defaultPictureParams {
-			if _, keyExists := p[k]; !keyExists {
-				p[k] = v
-			}
+	if flagset["sign-ttl"] {
+		config.Sign.TTL = *signTTL
+	}
+
+	if *signURL != "" {
+		if config.Sign.Secret == "" {
+			log.Fatal("sign.secret is not set in config")
 		}
-	} else {
-		config.Template["default"] = defaultPictureParams
+		u, err := url.Parse(*signURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		values := u.Query()
+		pkg.NewSigner(config.Sign.Secret, false).Sign(values, config.Sign.TTL)
+		u.RawQuery = values.Encode()
+		fmt.Println(u.String())
+		return
 	}
 
-	// encode default
-	values := url.Values{}
-	for k, v := range config.Template["default"] {
-		values.Set(k, fmt.Sprint(v))
+	pngRenderer, err := pkg.GetRenderer(config.Main.Renderer)
+	if err != nil {
+		log.Fatal(err)
 	}
-	png.SetTemplate("default", png.GetPictureParams(httptest.NewRequest("GET", "/?"+values.Encode(), nil), nil))
 
-	for templateName, templateData := range config.Template {
-		if templateName == "default" {
-			continue
-		}
-		values := url.Values{}
-		for k, v := range templateData {
-			values.Set(k, fmt.Sprint(v))
+	pngHandler := pkg.NewPNG(config.Main.PrometheusAddr, config.Main.PrometheusPath, config.Main.Timeout)
+	pngHandler.SetRenderer(pngRenderer)
+	switch config.AccessLog.File {
+	case "":
+	case "stdout":
+		pngHandler.SetLogger(pkg.NewAccessLogger(os.Stdout, config.AccessLog.Sample, config.AccessLog.SlowThreshold))
+	case "stderr":
+		pngHandler.SetLogger(pkg.NewAccessLogger(os.Stderr, config.AccessLog.Sample, config.AccessLog.SlowThreshold))
+	default:
+		f, err := os.OpenFile(config.AccessLog.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
 		}
-		png.SetTemplate(templateName, png.GetPictureParams(httptest.NewRequest("GET", "/?"+values.Encode(), nil), nil))
+		pngHandler.SetLogger(pkg.NewAccessLogger(f, config.AccessLog.Sample, config.AccessLog.SlowThreshold))
 	}
+	var fetchLimiter, renderLimiter *pkg.Limiter
+	if config.Limit.FetchConcurrency > 0 {
+		fetchLimiter = pkg.NewLimiter("fetch", config.Limit.FetchConcurrency, config.Limit.QueueSize, config.Limit.QueueTimeout)
+	}
+	if config.Limit.RenderConcurrency > 0 {
+		renderLimiter = pkg.NewLimiter("render", config.Limit.RenderConcurrency, config.Limit.QueueSize, config.Limit.QueueTimeout)
+	}
+	pngHandler.SetLimiters(fetchLimiter, renderLimiter)
 
-	http.Handle("/", pkg.NewPNG(config.Main.PrometheusAddr, config.Main.PrometheusPath, config.Main.Timeout))
-	log.Fatal(http.ListenAndServe(config.Main.Listen, nil))
+	handlerOptions, err := config.handlerOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pngHandler.Configure(handlerOptions)
+
+	metrics := pkg.NewMetrics()
+	if config.Cache.Size > 0 {
+		cache := pkg.NewCache(config.Cache.Size, config.Cache.TTL, config.Cache.Dir)
+		cache.SetDiskSize(config.Cache.DiskSize)
+		pngHandler.SetCache(cache)
+		metrics.AddCache(cache)
+	}
+	http.Handle("/", pngHandler)
+	http.Handle("/from-prometheus", pkg.NewFromPrometheus(pngHandler))
+	http.Handle("/metrics", metrics)
+	http.HandleFunc("/api/v1/templates", pkg.TemplatesHandler)
+
+	status := pkg.NewStatus(pngHandler, version, revision)
+	http.HandleFunc("/-/healthy", status.Healthy)
+	http.HandleFunc("/-/ready", status.Ready)
+	http.HandleFunc("/api/v1/status/buildinfo", status.BuildInfo)
+
+	configReloader := newReloader(*configFilename, pngHandler)
+	if config.Main.ReloadEndpoint {
+		http.Handle("/-/reload", configReloader)
+	}
+	go configReloader.Watch(config.Main.ReloadInterval)
+
+	if config.Alertmanager.NotifyURL != "" {
+		http.Handle("/alertmanager", pkg.NewAlertmanager(pngHandler, pkg.AlertmanagerOptions{
+			NotifyURL:    config.Alertmanager.NotifyURL,
+			FileField:    config.Alertmanager.FileField,
+			CaptionField: config.Alertmanager.CaptionField,
+			Fields:       config.Alertmanager.Fields,
+			Template:     config.Alertmanager.Template,
+			Before:       config.Alertmanager.Before,
+			After:        config.Alertmanager.After,
+			Timeout:      config.Alertmanager.Timeout,
+			Token:        config.Alertmanager.Token,
+		}))
+	}
+
+	server := &http.Server{
+		Addr:         config.Main.Listen,
+		ReadTimeout:  config.Main.ReadTimeout,
+		WriteTimeout: config.Main.WriteTimeout,
+		IdleTimeout:  config.Main.IdleTimeout,
+	}
+
+	// ListenAndServe returns immediately after Shutdown call, main waits until requests are drained
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+		<-sig
+		log.Printf("shutting down, waiting up to %s for active requests", config.Main.ShutdownTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.Main.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %s", err)
+			server.Close()
+		}
+	}()
+
+	if config.Main.TLSCertFile != "" || config.Main.TLSKeyFile != "" {
+		err = server.ListenAndServeTLS(config.Main.TLSCertFile, config.Main.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdownDone
 }
@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type upstreamQueryLog struct {
+	Expr     string  `json:"expr"`
+	Duration float64 `json:"duration"`
+	Series   int     `json:"series"`
+	Slow     bool    `json:"slow,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type accessRecord struct {
+	Time     string             `json:"time"`
+	Client   string             `json:"client"`
+	Key      string             `json:"key,omitempty"`
+	Method   string             `json:"method"`
+	Path     string             `json:"path"`
+	Params   map[string]string  `json:"params"`
+	Format   string             `json:"format,omitempty"`
+	Template string             `json:"template,omitempty"`
+	Cache    string             `json:"cache,omitempty"`
+	Queries  []upstreamQueryLog `json:"queries,omitempty"`
+	Render   float64            `json:"render_duration,omitempty"`
+	Duration float64            `json:"duration"`
+	Status   int                `json:"status"`
+	Size     int                `json:"size"`
+	Slow     bool               `json:"slow,omitempty"`
+	Error    string             `json:"error,omitempty"`
+}
+
+type accessRecordKey struct{}
+
+func withAccessRecord(ctx context.Context, rec *accessRecord) context.Context {
+	return context.WithValue(ctx, accessRecordKey{}, rec)
+}
+
+// accessRecordFrom returns nil if logging is disabled. All methods of accessRecord are nil-safe
+func accessRecordFrom(ctx context.Context) *accessRecord {
+	rec, _ := ctx.Value(accessRecordKey{}).(*accessRecord)
+	return rec
+}
+
+func (rec *accessRecord) addQuery(q upstreamQueryLog) {
+	if rec != nil {
+		rec.Queries = append(rec.Queries, q)
+	}
+}
+
+func (rec *accessRecord) setKey(name string) {
+	if rec != nil {
+		rec.Key = name
+	}
+}
+
+func (rec *accessRecord) setRender(d time.Duration) {
+	if rec != nil {
+		rec.Render = d.Seconds()
+	}
+}
+
+func requestClient(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AccessLogger writes one JSON line per render request.
+// Failed and slow requests are always logged, other requests are sampled
+type AccessLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	sample float64
+	slow   time.Duration
+	rand   func() float64
+}
+
+// NewAccessLogger creates logger. sample is fraction of logged requests (0..1), slow is slow query threshold (0 disables)
+func NewAccessLogger(w io.Writer, sample float64, slow time.Duration) *AccessLogger {
+	return &AccessLogger{
+		w:      w,
+		sample: sample,
+		slow:   slow,
+		rand:   rand.Float64,
+	}
+}
+
+func (l *AccessLogger) start(r *http.Request) *accessRecord {
+	params := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if k == "key" {
+			// api key name is logged instead
+			continue
+		}
+		params[k] = strings.Join(v, ",")
+	}
+	return &accessRecord{
+		Time:   timeNow().UTC().Format(time.RFC3339Nano),
+		Client: requestClient(r),
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Params: params,
+	}
+}
+
+func (l *AccessLogger) finish(rec *accessRecord, start time.Time) {
+	elapsed := time.Since(start)
+	rec.Duration = elapsed.Seconds()
+
+	if l.slow > 0 {
+		if elapsed >= l.slow {
+			rec.Slow = true
+		}
+		for i := range rec.Queries {
+			if time.Duration(rec.Queries[i].Duration*float64(time.Second)) >= l.slow {
+				rec.Queries[i].Slow = true
+				rec.Slow = true
+			}
+		}
+	}
+
+	if !rec.Slow && rec.Status < 400 && l.rand() >= l.sample {
+		return
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	l.w.Write(b)
+	l.mu.Unlock()
+}
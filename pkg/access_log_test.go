@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t, upSeries(1))
+	defer prom.Close()
+	prom.handle(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("query") == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return false
+		}
+		return true
+	})
+
+	var buf bytes.Buffer
+	logger := NewAccessLogger(&buf, 0, 0)
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetLogger(logger)
+
+	r := httptest.NewRequest("GET", "/?g0.expr=up&g1.expr=bad&format=json", nil)
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 127.0.0.1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	var rec accessRecord
+	assert.NoError(json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal("10.0.0.1", rec.Client)
+	assert.Equal("up", rec.Params["g0.expr"])
+	assert.Equal(http.StatusBadGateway, rec.Status)
+	assert.Equal("prometheus status: 400 Bad Request", rec.Error)
+	if assert.Len(rec.Queries, 2) {
+		assert.Equal(1, rec.Queries[0].Series)
+		assert.Equal("bad", rec.Queries[1].Expr)
+		assert.NotEmpty(rec.Queries[1].Error)
+	}
+
+	// successful requests are sampled
+	buf.Reset()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?g0.expr=up&format=json", nil))
+	assert.Equal(0, buf.Len())
+
+	logger.sample = 1
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?g0.expr=up&format=json", nil))
+	assert.Equal(1, strings.Count(buf.String(), "\n"))
+	assert.NoError(json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(http.StatusOK, rec.Status)
+	assert.True(rec.Size > 0)
+	assert.False(rec.Slow)
+
+	// slow requests are always logged
+	buf.Reset()
+	logger.sample = 0
+	logger.slow = time.Nanosecond
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?g0.expr=up&format=json", nil))
+	assert.NoError(json.Unmarshal(buf.Bytes(), &rec))
+	assert.True(rec.Slow)
+	assert.True(rec.Queries[0].Slow)
+}
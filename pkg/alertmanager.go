@@ -0,0 +1,349 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+	pb "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+type AlertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+}
+
+type AlertmanagerMessage struct {
+	Version  string              `json:"version"`
+	Status   string              `json:"status"`
+	Receiver string              `json:"receiver"`
+	Alerts   []AlertmanagerAlert `json:"alerts"`
+}
+
+// AlertmanagerOptions describes where rendered alert graphs are posted.
+// Image is sent as multipart/form-data file in FileField (Telegram sendPhoto style),
+// alert description in CaptionField and all Fields as extra form values (chat_id, channels, token etc)
+type AlertmanagerOptions struct {
+	NotifyURL    string
+	FileField    string
+	CaptionField string
+	Fields       map[string]string
+	Template     string
+	Before       time.Duration
+	After        time.Duration
+	Timeout      time.Duration
+	// webhook requests should have "Authorization: Bearer <token>" header if set
+	Token string
+}
+
+type Alertmanager struct {
+	png     *Handler
+	options AlertmanagerOptions
+	client  *http.Client
+}
+
+func NewAlertmanager(png *Handler, options AlertmanagerOptions) *Alertmanager {
+	if options.FileField == "" {
+		options.FileField = "photo"
+	}
+	if options.CaptionField == "" {
+		options.CaptionField = "caption"
+	}
+	if options.Before == 0 {
+		options.Before = time.Hour
+	}
+	if options.After == 0 {
+		options.After = 15 * time.Minute
+	}
+	if options.Timeout == 0 {
+		options.Timeout = png.defaultTimeout
+	}
+	return &Alertmanager{
+		png:     png,
+		options: options,
+		client:  http.DefaultClient,
+	}
+}
+
+var comparisonOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// splitThreshold splits "expr > 90" to "expr" and 90.
+// Only top-level comparison with number on the right side is recognized
+func splitThreshold(expr string) (string, float64, bool) {
+	depth := 0
+	var quote byte
+	for i := len(expr) - 1; i > 0; i-- {
+		c := expr[i]
+		if quote != 0 {
+			if c == quote && expr[i-1] != '\\' {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'', '`':
+			quote = c
+			continue
+		case ')', '}', ']':
+			depth++
+			continue
+		case '(', '{', '[':
+			depth--
+			continue
+		}
+		if depth != 0 {
+			continue
+		}
+		for _, op := range comparisonOperators {
+			if i+1 < len(op) || expr[i+1-len(op):i+1] != op {
+				continue
+			}
+			right := strings.TrimSpace(expr[i+1:])
+			right = strings.TrimSpace(strings.TrimPrefix(right, "bool"))
+			threshold, err := strconv.ParseFloat(right, 64)
+			if err != nil {
+				return expr, 0, false
+			}
+			return strings.TrimSpace(expr[:i+1-len(op)]), threshold, true
+		}
+	}
+	return expr, 0, false
+}
+
+func alertExpr(generatorURL string) (string, error) {
+	u, err := url.Parse(generatorURL)
+	if err != nil {
+		return "", err
+	}
+	expr := u.Query().Get("g0.expr")
+	if expr == "" {
+		return "", fmt.Errorf("g0.expr not found in generatorURL %#v", generatorURL)
+	}
+	return expr, nil
+}
+
+func thresholdSeries(md *types.MetricData) *types.MetricData {
+	setSeriesStyle(md, seriesStyle{color: "red", dashed: 5})
+	return md
+}
+
+func firingSeries(md *types.MetricData) *types.MetricData {
+	setSeriesStyle(md, seriesStyle{color: "red", drawAsInfinite: true, alpha: 0.2})
+	return md
+}
+
+func constantSeries(name string, value float64, from, until, step int64) *types.MetricData {
+	values := make([]float64, (until-from)/step+1)
+	for i := range values {
+		values[i] = value
+	}
+	return &types.MetricData{
+		FetchResponse: pb.FetchResponse{
+			Name:              name,
+			StartTime:         from,
+			StopTime:          from + int64(len(values)-1)*step,
+			StepTime:          step,
+			Values:            values,
+			ConsolidationFunc: "average",
+		},
+		ValuesPerPoint: 1,
+	}
+}
+
+func (am *Alertmanager) renderAlert(ctx context.Context, w http.ResponseWriter, alert *AlertmanagerAlert, key *apiKey) ([]byte, bool) {
+	expr, err := alertExpr(alert.GeneratorURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	expr, threshold, hasThreshold := splitThreshold(expr)
+
+	now := timeNow()
+	end := now
+	if alert.Status == "resolved" && !alert.EndsAt.IsZero() && alert.EndsAt.Before(now) {
+		end = alert.EndsAt
+	}
+	until := end.Add(am.options.After)
+	if until.After(now) {
+		until = now
+	}
+
+	values := url.Values{}
+	values.Set("g0.expr", expr)
+	values.Set("from", strconv.FormatInt(alert.StartsAt.Add(-am.options.Before).Unix(), 10))
+	values.Set("until", strconv.FormatInt(until.Unix(), 10))
+	values.Set("title", alert.Labels["alertname"])
+	if am.options.Template != "" {
+		values.Set("template", am.options.Template)
+	}
+
+	params, ok := am.png.parseRenderParams(w, httptest.NewRequest("GET", "/?"+values.Encode(), nil), am.png.getState())
+	if !ok {
+		return nil, false
+	}
+	// expression from generatorURL is checked like any other query
+	if !am.png.checkAccess(w, params, key) {
+		return nil, false
+	}
+
+	metricData, ok := am.png.fetch(ctx, w, params)
+	if !ok {
+		return nil, false
+	}
+
+	if hasThreshold {
+		metricData = append(metricData, thresholdSeries(constantSeries("threshold", threshold, params.from, params.until, params.step)))
+	}
+
+	firing := constantSeries("firing", 1, params.from, params.until, params.step)
+	for i := range firing.Values {
+		t := firing.StartTime + int64(i)*firing.StepTime
+		if t < alert.StartsAt.Unix() || t > end.Unix() {
+			firing.Values[i] = math.NaN()
+		}
+	}
+	metricData = append(metricData, firingSeries(firing))
+	defer releaseSeriesStyles(metricData)
+
+	response, _, err := am.png.render(httptest.NewRequest("GET", "/?"+values.Encode(), nil), params, metricData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return response, true
+}
+
+func alertCaption(alert *AlertmanagerAlert) string {
+	caption := fmt.Sprintf("[%s] %s", strings.ToUpper(alert.Status), alert.Labels["alertname"])
+	if summary := alert.Annotations["summary"]; summary != "" {
+		caption += "\n" + summary
+	}
+	if description := alert.Annotations["description"]; description != "" {
+		caption += "\n" + description
+	}
+	return caption
+}
+
+func (am *Alertmanager) post(ctx context.Context, image []byte, caption string) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	for k, v := range am.options.Fields {
+		if err := mw.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+	if err := mw.WriteField(am.options.CaptionField, caption); err != nil {
+		return err
+	}
+	fw, err := mw.CreateFormFile(am.options.FileField, "graph.png")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(image); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", am.options.NotifyURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	res, err := am.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("notify status: %s", res.Status)
+	}
+	return nil
+}
+
+func (am *Alertmanager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if am.options.Token != "" {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(am.options.Token)) != 1 {
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// webhook url may carry api key as ?key=
+	var key *apiKey
+	if apiKeys := am.png.getState().apiKeys; apiKeys != nil {
+		var ok bool
+		if key, ok = apiKeys.authenticate(w, r); !ok {
+			return
+		}
+	}
+
+	msg := &AlertmanagerMessage{}
+	if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// failed alert doesn't stop the rest, response status is the worst one
+	status := http.StatusOK
+	var errs []string
+	for i := range msg.Alerts {
+		alert := &msg.Alerts[i]
+		if code, err := am.notify(r.Context(), alert, key); err != nil {
+			if code > status {
+				status = code
+			}
+			errs = append(errs, fmt.Sprintf("%s: %s", alert.Labels["alertname"], err))
+		}
+	}
+	if len(errs) > 0 {
+		http.Error(w, strings.Join(errs, "\n"), status)
+	}
+}
+
+// notify renders and posts single alert within own timeout
+func (am *Alertmanager) notify(ctx context.Context, alert *AlertmanagerAlert, key *apiKey) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, am.options.Timeout)
+	defer cancel()
+
+	rec := httptest.NewRecorder()
+	image, ok := am.renderAlert(ctx, rec, alert, key)
+	if !ok {
+		return rec.Code, errors.New(strings.TrimSpace(rec.Body.String()))
+	}
+	if err := am.post(ctx, image, alertCaption(alert)); err != nil {
+		return http.StatusBadGateway, err
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitThreshold(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		expr      string
+		left      string
+		threshold float64
+		ok        bool
+	}{
+		{"up == 0", "up", 0, true},
+		{"rate(errors_total[5m]) > 0.5", "rate(errors_total[5m])", 0.5, true},
+		{`node_load1{instance=~"a|b"} >= 10`, `node_load1{instance=~"a|b"}`, 10, true},
+		{"sum(x > 5) by (job)", "sum(x > 5) by (job)", 0, false},
+		{"a > b", "a > b", 0, false},
+		{"up", "up", 0, false},
+	}
+
+	for _, c := range table {
+		left, threshold, ok := splitThreshold(c.expr)
+		assert.Equal(c.left, left, c.expr)
+		assert.Equal(c.threshold, threshold, c.expr)
+		assert.Equal(c.ok, ok, c.expr)
+	}
+}
+
+func TestAlertmanager(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t, fakeSeries{labels: map[string]string{"__name__": "up"}, start: 1537555344, step: 60, values: []float64{1, 0}})
+	defer prom.Close()
+
+	posted := make(map[string]string)
+	var postedFile bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range r.MultipartForm.Value {
+			posted[k] = v[0]
+		}
+		_, postedFile = r.MultipartForm.File["photo"]
+	}))
+	defer receiver.Close()
+
+	am := NewAlertmanager(NewPNG(prom.URL, "/api/v1/query_range", time.Second), AlertmanagerOptions{
+		NotifyURL: receiver.URL,
+		Fields:    map[string]string{"chat_id": "42"},
+	})
+
+	body := `{"status":"firing","alerts":[{"status":"firing","labels":{"alertname":"InstanceDown"},"annotations":{"summary":"instance is down"},"startsAt":"2018-09-21T18:43:00Z","generatorURL":"http://prometheus:9090/graph?g0.expr=up+%3D%3D+0&g0.tab=1"}]}`
+	w := httptest.NewRecorder()
+	am.ServeHTTP(w, httptest.NewRequest("POST", "/alertmanager", strings.NewReader(body)))
+
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	if queries := prom.queries(); assert.Len(queries, 1) {
+		assert.Equal("up", queries[0].Get("query"))
+	}
+	assert.Equal("42", posted["chat_id"])
+	assert.Equal("[FIRING] InstanceDown\ninstance is down", posted["caption"])
+	assert.True(postedFile)
+}
+
+func TestAlertmanagerPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t, upSeries(1, 0))
+	defer prom.Close()
+
+	var posts int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+	}))
+	defer receiver.Close()
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	policy, err := NewPolicy(PolicyOptions{MetricDeny: []string{"secret_.*"}})
+	assert.NoError(err)
+	h.SetPolicy(policy)
+	am := NewAlertmanager(h, AlertmanagerOptions{NotifyURL: receiver.URL})
+
+	body := `{"status":"firing","alerts":[{"status":"firing","labels":{"alertname":"Leak"},"startsAt":"2018-09-21T18:43:00Z","generatorURL":"http://prometheus:9090/graph?g0.expr=secret_token+%3E+0"}]}`
+	w := httptest.NewRecorder()
+	am.ServeHTTP(w, httptest.NewRequest("POST", "/alertmanager", strings.NewReader(body)))
+
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Len(prom.queries(), 0)
+	assert.Equal(0, posts)
+}
+
+func TestAlertmanagerToken(t *testing.T) {
+	assert := assert.New(t)
+
+	am := NewAlertmanager(NewPNG("http://127.0.0.1:1", "/api/v1/query_range", time.Second), AlertmanagerOptions{
+		NotifyURL: "http://127.0.0.1:1",
+		Token:     "secret",
+	})
+
+	for _, header := range []string{"", "Bearer wrong", "secret"} {
+		r := httptest.NewRequest("POST", "/alertmanager", strings.NewReader(`{"alerts":[]}`))
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		am.ServeHTTP(w, r)
+		assert.Equal(http.StatusUnauthorized, w.Code, header)
+	}
+
+	r := httptest.NewRequest("POST", "/alertmanager", strings.NewReader(`{"alerts":[]}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	am.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+}
+
+func TestAlertmanagerAPIKey(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t, upSeries(1, 0))
+	defer prom.Close()
+
+	var posts int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+	}))
+	defer receiver.Close()
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	policy, err := NewPolicy(PolicyOptions{MetricDeny: []string{"up"}})
+	assert.NoError(err)
+	h.SetAPIKeys(NewAPIKeys("", []APIKeyOptions{
+		{Key: "full", Name: "full"},
+		{Key: "limited", Name: "limited", Policy: policy},
+	}))
+	am := NewAlertmanager(h, AlertmanagerOptions{NotifyURL: receiver.URL})
+
+	body := `{"status":"firing","alerts":[{"status":"firing","labels":{"alertname":"InstanceDown"},"startsAt":"2018-09-21T18:43:00Z","generatorURL":"http://prometheus:9090/graph?g0.expr=up+%3D%3D+0"}]}`
+	table := []struct {
+		url  string
+		code int
+	}{
+		{"/alertmanager", http.StatusUnauthorized},
+		{"/alertmanager?key=wrong", http.StatusUnauthorized},
+		{"/alertmanager?key=limited", http.StatusForbidden},
+		{"/alertmanager?key=full", http.StatusOK},
+	}
+
+	for _, c := range table {
+		w := httptest.NewRecorder()
+		am.ServeHTTP(w, httptest.NewRequest("POST", c.url, strings.NewReader(body)))
+		assert.Equal(c.code, w.Code, c.url)
+	}
+	assert.Len(prom.queries(), 1)
+	assert.Equal(1, posts)
+}
+
+func TestAlertmanagerErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t, upSeries(1, 0))
+	defer prom.Close()
+	// first query exceeds alert timeout
+	var queries int32
+	prom.handle(func(w http.ResponseWriter, r *http.Request) bool {
+		if atomic.AddInt32(&queries, 1) == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return true
+	})
+
+	var posted []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		posted = append(posted, r.MultipartForm.Value["caption"][0])
+		if strings.Contains(r.MultipartForm.Value["caption"][0], "Rejected") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	am := NewAlertmanager(NewPNG(prom.URL, "/api/v1/query_range", time.Second), AlertmanagerOptions{
+		NotifyURL: receiver.URL,
+		Timeout:   200 * time.Millisecond,
+	})
+
+	w := httptest.NewRecorder()
+	am.ServeHTTP(w, httptest.NewRequest("GET", "/alertmanager", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+	assert.Equal("POST", w.Header().Get("Allow"))
+
+	alert := func(name string, generatorURL string) string {
+		return `{"status":"firing","labels":{"alertname":"` + name + `"},"startsAt":"2018-09-21T18:43:00Z","generatorURL":"` + generatorURL + `"}`
+	}
+	body := `{"status":"firing","alerts":[` +
+		alert("Slow", "http://prometheus:9090/graph?g0.expr=up") + `,` +
+		alert("NoExpr", "http://prometheus:9090/graph") + `,` +
+		alert("Rejected", "http://prometheus:9090/graph?g0.expr=up") + `,` +
+		alert("Delivered", "http://prometheus:9090/graph?g0.expr=up") + `]}`
+	w = httptest.NewRecorder()
+	am.ServeHTTP(w, httptest.NewRequest("POST", "/alertmanager", strings.NewReader(body)))
+
+	assert.Equal(http.StatusBadGateway, w.Code)
+	assert.Contains(w.Body.String(), "Slow: ")
+	assert.Contains(w.Body.String(), "NoExpr: ")
+	assert.Contains(w.Body.String(), "Rejected: notify status: 500")
+	assert.NotContains(w.Body.String(), "Delivered")
+	assert.Equal([]string{"[FIRING] Rejected", "[FIRING] Delivered"}, posted)
+}
@@ -0,0 +1,145 @@
+package pkg
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errAPIKeyRequired = errors.New("api key required")
+	errAPIKeyInvalid  = errors.New("invalid api key")
+)
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: timeNow()}
+}
+
+// take returns false and time until next token if bucket is empty
+func (b *tokenBucket) take() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := timeNow()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// APIKeyOptions describes access of single key. Empty lists allow everything
+type APIKeyOptions struct {
+	Name        string
+	Key         string
+	Templates   []string
+	Datasources []string
+	Policy      *Policy
+	// requests per second, 0 - unlimited
+	Rate  float64
+	Burst int
+}
+
+type apiKey struct {
+	name        string
+	templates   map[string]bool
+	datasources map[string]bool
+	policy      *Policy
+	bucket      *tokenBucket
+}
+
+func (k *apiKey) allowTemplate(name string) bool {
+	if name == "" {
+		name = "default"
+	}
+	return len(k.templates) == 0 || k.templates[name]
+}
+
+func (k *apiKey) allowDatasource(name string) bool {
+	return len(k.datasources) == 0 || k.datasources[name]
+}
+
+func stringSet(list []string) map[string]bool {
+	m := make(map[string]bool, len(list))
+	for _, s := range list {
+		m[s] = true
+	}
+	return m
+}
+
+// APIKeys authenticates requests by X-API-Key header or key parameter
+type APIKeys struct {
+	header string
+	keys   map[string]*apiKey
+}
+
+func NewAPIKeys(header string, options []APIKeyOptions) *APIKeys {
+	if header == "" {
+		header = "X-API-Key"
+	}
+	a := &APIKeys{header: header, keys: make(map[string]*apiKey)}
+	for _, o := range options {
+		k := &apiKey{
+			name:        o.Name,
+			templates:   stringSet(o.Templates),
+			datasources: stringSet(o.Datasources),
+			policy:      o.Policy,
+		}
+		if o.Rate > 0 {
+			k.bucket = newTokenBucket(o.Rate, o.Burst)
+		}
+		a.keys[o.Key] = k
+	}
+	return a
+}
+
+func (a *APIKeys) lookup(r *http.Request) (*apiKey, error) {
+	key := r.Header.Get(a.header)
+	if key == "" {
+		key = r.URL.Query().Get("key")
+	}
+	if key == "" {
+		return nil, errAPIKeyRequired
+	}
+	k, exists := a.keys[key]
+	if !exists {
+		return nil, errAPIKeyInvalid
+	}
+	return k, nil
+}
+
+// authenticate writes error response and returns false if request has no valid key or key is over quota
+func (a *APIKeys) authenticate(w http.ResponseWriter, r *http.Request) (*apiKey, bool) {
+	k, err := a.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	accessRecordFrom(r.Context()).setKey(k.name)
+
+	if k.bucket != nil {
+		if ok, wait := k.bucket.take(); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return nil, false
+		}
+	}
+	return k, true
+}
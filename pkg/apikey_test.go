@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return now }
+
+	b := newTokenBucket(0.5, 2)
+	ok, _ := b.take()
+	assert.True(ok)
+	ok, _ = b.take()
+	assert.True(ok)
+	ok, wait := b.take()
+	assert.False(ok)
+	assert.Equal(2*time.Second, wait)
+
+	now = now.Add(2 * time.Second)
+	ok, _ = b.take()
+	assert.True(ok)
+}
+
+func TestAPIKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t)
+	defer prom.Close()
+
+	defer SetTemplates(map[string]url.Values{})
+	SetTemplates(map[string]url.Values{"default": {}, "dark": {}})
+
+	policy, _ := NewPolicy(PolicyOptions{MetricAllow: []string{"up"}})
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetAPIKeys(NewAPIKeys("", []APIKeyOptions{
+		{Name: "a", Key: "aaa", Templates: []string{"default"}, Policy: policy, Rate: 1, Burst: 1},
+		{Name: "b", Key: "bbb", Datasources: []string{"other"}},
+	}))
+
+	get := func(query string, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?format=json&"+query, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(http.StatusUnauthorized, get("g0.expr=up", "").Code)
+	assert.Equal(http.StatusUnauthorized, get("g0.expr=up", "xxx").Code)
+	assert.Equal(http.StatusForbidden, get("g0.expr=up", "bbb").Code)
+
+	assert.Equal(http.StatusOK, get("g0.expr=up&key=aaa", "").Code)
+	w := get("g0.expr=up", "aaa")
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal("1", w.Header().Get("Retry-After"))
+
+	defer func() { timeNow = time.Now }()
+	now := time.Now()
+	timeNow = func() time.Time { now = now.Add(time.Second); return now }
+	assert.Equal(http.StatusForbidden, get("g0.expr=up&template=dark", "aaa").Code)
+	assert.Equal(http.StatusForbidden, get("g0.expr=node_load1", "aaa").Code)
+	assert.Equal(http.StatusOK, get("g0.expr=up", "aaa").Code)
+}
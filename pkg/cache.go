@@ -0,0 +1,322 @@
+package pkg
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cachedResponse struct {
+	status      int
+	contentType string
+	body        []byte
+
+	gzipOnce sync.Once
+	gzipBody []byte
+}
+
+// gzipped returns gzip-encoded body. Body is encoded once and shared by all hits of cached response
+func (r *cachedResponse) gzipped() []byte {
+	r.gzipOnce.Do(func() {
+		r.gzipBody, _ = gzipBody(r.body)
+	})
+	return r.gzipBody
+}
+
+type cacheItem struct {
+	key      string
+	response *cachedResponse
+	expire   time.Time
+}
+
+type cacheCall struct {
+	wg       sync.WaitGroup
+	response *cachedResponse
+}
+
+// Cache is in-memory LRU cache of rendered responses with optional on-disk storage.
+// Concurrent requests for the same key are collapsed to single render
+type Cache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	ttl     time.Duration
+	dir     string
+	lru     *list.List
+	items   map[string]*list.Element
+	calls   map[string]*cacheCall
+
+	// on-disk storage limit, size is approximate between sweeps
+	diskMaxSize int64
+	diskSize    int64
+	lastSweep   time.Time
+	sweeping    bool
+}
+
+func NewCache(maxSize int64, ttl time.Duration, dir string) *Cache {
+	return &Cache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		dir:     dir,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+		calls:   make(map[string]*cacheCall),
+	}
+}
+
+// SetDiskSize sets max size of on-disk storage. 0 is unlimited, expired files are removed anyway
+func (c *Cache) SetDiskSize(maxSize int64) {
+	c.diskMaxSize = maxSize
+}
+
+func (c *Cache) filename(key string) string {
+	h := sha1.Sum([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:]))
+}
+
+// disk file format: expire (unix, 8 bytes), content type, '\n', body
+func (c *Cache) readFile(key string) (*cachedResponse, time.Time, bool) {
+	data, err := ioutil.ReadFile(c.filename(key))
+	if err != nil || len(data) < 8 {
+		return nil, time.Time{}, false
+	}
+	expire := time.Unix(int64(binary.BigEndian.Uint64(data[:8])), 0)
+	if timeNow().After(expire) {
+		os.Remove(c.filename(key))
+		return nil, time.Time{}, false
+	}
+	p := bytes.IndexByte(data[8:], '\n')
+	if p < 0 {
+		return nil, time.Time{}, false
+	}
+	return &cachedResponse{
+		status:      200,
+		contentType: string(data[8 : 8+p]),
+		body:        data[8+p+1:],
+	}, expire, true
+}
+
+// writeFile returns size of written file
+func (c *Cache) writeFile(key string, response *cachedResponse, expire time.Time) int64 {
+	data := make([]byte, 8, 8+len(response.contentType)+1+len(response.body))
+	binary.BigEndian.PutUint64(data, uint64(expire.Unix()))
+	data = append(data, response.contentType...)
+	data = append(data, '\n')
+	data = append(data, response.body...)
+
+	tmp := c.filename(key) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return 0
+	}
+	if err := os.Rename(tmp, c.filename(key)); err != nil {
+		os.Remove(tmp)
+		return 0
+	}
+	return int64(len(data))
+}
+
+type cacheFile struct {
+	path   string
+	size   int64
+	expire time.Time
+}
+
+// sweep removes expired files and files with nearest expiration while storage is over size limit
+func (c *Cache) sweep(now time.Time) {
+	var files []cacheFile
+	var total int64
+
+	entries, _ := ioutil.ReadDir(c.dir)
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(c.dir, name)
+		if !e.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			// leftover of failed write
+			if now.Sub(e.ModTime()) > time.Minute {
+				os.Remove(path)
+			}
+			continue
+		}
+		if _, err := hex.DecodeString(name); err != nil || len(name) != 2*sha1.Size {
+			continue
+		}
+		expire, ok := readFileExpire(path)
+		if !ok || now.After(expire) {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, cacheFile{path, e.Size(), expire})
+		total += e.Size()
+	}
+
+	if c.diskMaxSize > 0 && total > c.diskMaxSize {
+		sort.Slice(files, func(i, j int) bool { return files[i].expire.Before(files[j].expire) })
+		for _, f := range files {
+			if total <= c.diskMaxSize {
+				break
+			}
+			if os.Remove(f.path) == nil {
+				total -= f.size
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.diskSize = total
+	c.lastSweep = now
+	c.sweeping = false
+	c.mu.Unlock()
+}
+
+func readFileExpire(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	var buf [8]byte
+	if _, err := io.ReadFull(f, buf[:]); err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(buf[:])), 0), true
+}
+
+func (c *Cache) removeElement(e *list.Element) {
+	item := e.Value.(*cacheItem)
+	c.lru.Remove(e)
+	delete(c.items, item.key)
+	c.size -= int64(len(item.response.body))
+}
+
+func (c *Cache) set(key string, response *cachedResponse, expire time.Time) {
+	size := int64(len(response.body))
+	if size > c.maxSize {
+		return
+	}
+	if e, exists := c.items[key]; exists {
+		c.removeElement(e)
+	}
+	c.items[key] = c.lru.PushFront(&cacheItem{key: key, response: response, expire: expire})
+	c.size += size
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// get must be called with lock
+func (c *Cache) get(key string) (*cachedResponse, bool) {
+	if e, exists := c.items[key]; exists {
+		item := e.Value.(*cacheItem)
+		if timeNow().Before(item.expire) {
+			c.lru.MoveToFront(e)
+			return item.response, true
+		}
+		c.removeElement(e)
+	}
+	if c.dir != "" {
+		if response, expire, ok := c.readFile(key); ok {
+			c.set(key, response, expire)
+			return response, true
+		}
+	}
+	return nil, false
+}
+
+// Do returns cached response or calls fn. Only successful responses are stored and reported as hit
+func (c *Cache) Do(key string, fn func() *cachedResponse) (*cachedResponse, bool) {
+	c.mu.Lock()
+	if response, ok := c.get(key); ok {
+		c.mu.Unlock()
+		return response, true
+	}
+	if call, exists := c.calls[key]; exists {
+		c.mu.Unlock()
+		call.wg.Wait()
+		if call.response == nil {
+			// shared call panicked
+			return fn(), false
+		}
+		return call.response, call.response.status == 200
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	// waiters are released and key is unlocked even if fn panics
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	response := fn()
+	expire := timeNow().Add(c.ttl)
+
+	if response.status == 200 {
+		c.mu.Lock()
+		c.set(key, response, expire)
+		c.mu.Unlock()
+
+		if c.dir != "" {
+			size := c.writeFile(key, response, expire)
+
+			now := timeNow()
+			c.mu.Lock()
+			c.diskSize += size
+			sweep := !c.sweeping && ((c.diskMaxSize > 0 && c.diskSize > c.diskMaxSize) || now.Sub(c.lastSweep) >= c.ttl)
+			if sweep {
+				c.sweeping = true
+			}
+			c.mu.Unlock()
+
+			if sweep {
+				go c.sweep(now)
+			}
+		}
+	}
+
+	call.response = response
+	return response, false
+}
+
+// Stats returns number of items and total size of cached responses in memory
+func (c *Cache) Stats() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items), c.size
+}
+
+// cacheKey is normalized request parameters with from, until and step of every graph query
+func cacheKey(query url.Values, params *renderParams) string {
+	values := url.Values{}
+	for k, v := range query {
+		switch k {
+		case "from", "until", "timeout", "format", "sig", "exp", "key":
+			continue
+		}
+		values[k] = v
+	}
+	values.Set("format", params.Format)
+	for i, g := range params.G {
+		values.Set(fmt.Sprintf("g%d.range", i), fmt.Sprintf("%d:%d:%d", g.from, g.until, g.step))
+	}
+	// Encode sorts by key
+	return values.Encode()
+}
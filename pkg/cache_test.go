@@ -0,0 +1,242 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewCache(10, time.Minute, "")
+
+	render := func(body string) func() *cachedResponse {
+		return func() *cachedResponse {
+			return &cachedResponse{status: 200, contentType: "text/plain", body: []byte(body)}
+		}
+	}
+
+	res, hit := c.Do("a", render("aaaa"))
+	assert.False(hit)
+	assert.Equal("aaaa", string(res.body))
+
+	res, hit = c.Do("a", render("xxxx"))
+	assert.True(hit)
+	assert.Equal("aaaa", string(res.body))
+
+	c.Do("b", render("bbbb"))
+	c.Do("a", nil)
+	// "b" is least recently used
+	c.Do("c", render("cccc"))
+	_, hit = c.Do("b", render("bbbb"))
+	assert.False(hit)
+
+	items, size := c.Stats()
+	assert.Equal(2, items)
+	assert.Equal(int64(8), size)
+
+	// errors are not cached
+	c.Do("e", func() *cachedResponse { return &cachedResponse{status: 502} })
+	_, hit = c.Do("e", render("e"))
+	assert.False(hit)
+
+	// ttl
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	_, hit = c.Do("a", render("aaaa"))
+	assert.False(hit)
+}
+
+func TestCacheCollapse(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewCache(1000, time.Minute, "")
+
+	var calls int32
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			c.Do("key", func() *cachedResponse {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return &cachedResponse{status: 200, body: []byte("body")}
+			})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(int32(1), calls)
+}
+
+func TestCachePanic(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewCache(1000, time.Minute, "")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		c.Do("key", func() *cachedResponse {
+			close(started)
+			<-release
+			panic("render failed")
+		})
+	}()
+	<-started
+
+	done := make(chan *cachedResponse)
+	go func() {
+		res, _ := c.Do("key", func() *cachedResponse { return &cachedResponse{status: 200, body: []byte("body")} })
+		done <- res
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	select {
+	case res := <-done:
+		assert.Equal("body", string(res.body))
+	case <-time.After(time.Second):
+		t.Fatal("waiter is blocked after panic")
+	}
+
+	res, hit := c.Do("key", func() *cachedResponse { return &cachedResponse{status: 200, body: []byte("new")} })
+	assert.False(hit)
+	assert.Equal("new", string(res.body))
+}
+
+func TestCacheDisk(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "prometheus-png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewCache(1000, time.Minute, dir)
+	c.Do("key", func() *cachedResponse {
+		return &cachedResponse{status: 200, contentType: "image/png", body: []byte("body")}
+	})
+
+	// new cache with empty memory
+	c = NewCache(1000, time.Minute, dir)
+	res, hit := c.Do("key", nil)
+	assert.True(hit)
+	assert.Equal("image/png", res.contentType)
+	assert.Equal("body", string(res.body))
+}
+
+func TestCacheDetachedRender(t *testing.T) {
+	assert := assert.New(t)
+
+	queried := make(chan struct{})
+	release := make(chan struct{})
+	prom := fakePrometheus(t, upSeries(1))
+	defer prom.Close()
+	prom.handle(func(w http.ResponseWriter, r *http.Request) bool {
+		close(queried)
+		<-release
+		return true
+	})
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetCache(NewCache(1<<20, time.Minute, ""))
+
+	const url = "/?g0.expr=up&from=1537555320&until=1537594920&format=csv"
+
+	// first client goes away while shared render is in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	first := httptest.NewRecorder()
+	firstDone := make(chan struct{})
+	go func() {
+		h.ServeHTTP(first, httptest.NewRequest("GET", url, nil).WithContext(ctx))
+		close(firstDone)
+	}()
+	<-queried
+
+	second := httptest.NewRecorder()
+	secondDone := make(chan struct{})
+	go func() {
+		h.ServeHTTP(second, httptest.NewRequest("GET", url, nil))
+		close(secondDone)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	close(release)
+	<-firstDone
+	<-secondDone
+
+	assert.Equal(http.StatusOK, second.Code)
+	assert.Equal("HIT", second.Header().Get("X-Cache"))
+	assert.Contains(second.Body.String(), `"up",`)
+}
+
+func TestCacheDiskSweep(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "prometheus-png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewCache(1000, time.Minute, dir)
+	c.SetDiskSize(100)
+
+	now := time.Now()
+	response := &cachedResponse{status: 200, contentType: "text/plain", body: make([]byte, 30)}
+	c.writeFile("expired", response, now.Add(-time.Second))
+	c.writeFile("a", response, now.Add(time.Minute))
+	c.writeFile("b", response, now.Add(3*time.Minute))
+	c.writeFile("c", response, now.Add(2*time.Minute))
+	ioutil.WriteFile(filepath.Join(dir, "other"), []byte("not cache file"), 0644)
+
+	c.sweep(now)
+
+	exists := func(key string) bool {
+		_, err := os.Stat(c.filename(key))
+		return err == nil
+	}
+	assert.False(exists("expired"))
+	// the nearest expiration is removed first
+	assert.False(exists("a"))
+	assert.True(exists("b"))
+	assert.True(exists("c"))
+	_, err = os.Stat(filepath.Join(dir, "other"))
+	assert.NoError(err)
+
+	assert.True(c.diskSize <= 100)
+}
+
+func TestCacheKey(t *testing.T) {
+	assert := assert.New(t)
+
+	query := url.Values{"g0.expr": {"up"}, "g0.ds": {"slow"}, "from": {"-1h"}}
+	key := func(from, until int64) string {
+		return cacheKey(query, &renderParams{
+			Format: "png",
+			G:      map[int]*graphParams{0: {Expr: "up", from: from, until: until, step: 70}},
+		})
+	}
+
+	assert.Equal(key(70, 3570), key(70, 3570))
+	// the same request step, different aligned range of graph
+	assert.NotEqual(key(0, 3570), key(70, 3570))
+}
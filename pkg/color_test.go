@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseColor(t *testing.T) {
+	assert := assert.New(t)
+
+	c, ok := parseColor("#102030")
+	assert.True(ok)
+	assert.Equal(uint8(0x20), c.G)
+	assert.Equal(uint8(0xff), c.A)
+
+	_, ok = parseColor("fff")
+	assert.True(ok)
+	_, ok = parseColor("Blue")
+	assert.True(ok)
+	for _, name := range []string{"navy", "steelblue", "teal", "graphiteblue"} {
+		_, ok = parseColor(name)
+		assert.True(ok, name)
+	}
+	_, ok = parseColor("nocolor")
+	assert.False(ok)
+
+	errs := CheckTemplateColors(url.Values{
+		"bgcolor":   {"171819"},
+		"fgcolor":   {"12345"},
+		"colorList": {"red, blue,xxx"},
+		"title":     {"not a color"},
+	})
+	assert.Len(errs, 2)
+	assert.Contains(errs, "fgcolor")
+	assert.Contains(errs, "colorList")
+}
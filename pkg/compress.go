@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// image formats are already compressed
+var compressibleFormats = map[string]bool{
+	"svg":  true,
+	"csv":  true,
+	"json": true,
+	"raw":  true,
+	"txt":  true,
+	"ansi": true,
+}
+
+// acceptsGzip reports whether Accept-Encoding header allows gzip. Explicit gzip entry overrides "*"
+func acceptsGzip(acceptEncoding string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if coding == "gzip" {
+			gzipQ = q
+		} else {
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+func gzipBody(body []byte) ([]byte, error) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decodedBody wraps upstream response body according to Content-Encoding. Close doesn't close body
+func decodedBody(contentEncoding string, body io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(contentEncoding) {
+	case "gzip":
+		return gzip.NewReader(body)
+	}
+	return ioutil.NopCloser(body), nil
+}
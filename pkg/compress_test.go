@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		header string
+		gzip   bool
+	}{
+		{"", false},
+		{"identity", false},
+		{"gzip;q=0", false},
+		{"deflate, GZIP;q=0.5", true},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+		{"gzip, *;q=0", true},
+	}
+
+	for _, c := range table {
+		assert.Equal(c.gzip, acceptsGzip(c.header), c.header)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	// fake prometheus gzips responses
+	prom := fakePrometheus(t, upSeries(1))
+	defer prom.Close()
+	prom.handle(func(w http.ResponseWriter, r *http.Request) bool {
+		assert.Equal("gzip", r.Header.Get("Accept-Encoding"))
+		return true
+	})
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&format=csv", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+	assert.Contains(w.Body.String(), `"up",`)
+	etag := w.Header().Get("ETag")
+
+	r := httptest.NewRequest("GET", "/?g0.expr=up&format=csv", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal("gzip", w.Header().Get("Content-Encoding"))
+	assert.Contains(w.Header()["Vary"], "Accept-Encoding")
+	assert.NotEqual(etag, w.Header().Get("ETag"))
+
+	zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if assert.NoError(err) {
+		body, _ := ioutil.ReadAll(zr)
+		assert.Contains(string(body), `"up",`)
+	}
+
+	// images are not compressed
+	r = httptest.NewRequest("GET", "/?g0.expr=up&format=png", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+}
+
+func TestCachedResponseGzip(t *testing.T) {
+	assert := assert.New(t)
+
+	response := &cachedResponse{status: 200, body: []byte("a,b,c")}
+	gz := response.gzipped()
+	// encoded once
+	assert.True(&gz[0] == &response.gzipped()[0])
+
+	zr, err := gzip.NewReader(bytes.NewReader(gz))
+	if assert.NoError(err) {
+		body, _ := ioutil.ReadAll(zr)
+		assert.Equal("a,b,c", string(body))
+	}
+}
@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"context"
+	"math"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+	pb "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+// Datasource returns series evaluated at start, start+step, ..., end.
+// Series may be shorter than requested range, leading and trailing gaps are trimmed
+type Datasource interface {
+	QueryRange(ctx context.Context, expr string, start, end, step int64) ([]*Series, error)
+}
+
+// Series is fetched data with labels for gN.legend and gN.filter. Name is set from legend by handler.
+// Datasource returns Series instead of plain *types.MetricData because vendored MetricData has no tags
+type Series struct {
+	*types.MetricData
+	Labels map[string]string
+}
+
+func newSeries(labels map[string]string, start, step int64, values []float64) *Series {
+	return &Series{
+		MetricData: &types.MetricData{
+			FetchResponse: pb.FetchResponse{
+				StartTime:         start,
+				StopTime:          start + int64(len(values)-1)*step,
+				StepTime:          step,
+				Values:            values,
+				ConsolidationFunc: "average",
+			},
+			ValuesPerPoint: 1,
+		},
+		Labels: labels,
+	}
+}
+
+func matrixToSeries(matrix []*matrixSeries) []*Series {
+	result := make([]*Series, 0, len(matrix))
+	for _, s := range matrix {
+		result = append(result, newSeries(s.metric, s.start, s.step, s.values))
+	}
+	return result
+}
+
+// consolidatedSeries collects raw samples to buckets (t - step, t] for t = start, start+step, ..., end
+type consolidatedSeries struct {
+	start int64
+	step  int64
+	sum   []float64
+	count []int
+}
+
+func newConsolidatedSeries(start, end, step int64) *consolidatedSeries {
+	points := int((end-start)/step) + 1
+	return &consolidatedSeries{start: start, step: step, sum: make([]float64, points), count: make([]int, points)}
+}
+
+func (c *consolidatedSeries) add(timestamp float64, value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	i := int(math.Ceil((timestamp - float64(c.start)) / float64(c.step)))
+	if i < 0 || i >= len(c.sum) {
+		return
+	}
+	c.sum[i] += value
+	c.count[i]++
+}
+
+// series returns averages of buckets without leading and trailing empty buckets. nil if series is empty
+func (c *consolidatedSeries) series(labels map[string]string) *Series {
+	first, last := -1, -1
+	for i, n := range c.count {
+		if n > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	values := make([]float64, last-first+1)
+	for i := range values {
+		if n := c.count[first+i]; n > 0 {
+			values[i] = c.sum[first+i] / float64(n)
+		} else {
+			values[i] = math.NaN()
+		}
+	}
+	return newSeries(labels, c.start+int64(first)*c.step, c.step, values)
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSeries is returned by fakePrometheus for every query. Points are at start, start+step, ...
+// Zero start and step are taken from query, NaN values are skipped
+type fakeSeries struct {
+	labels map[string]string
+	start  int64
+	step   int64
+	values []float64
+}
+
+// fakePromServer is query_range endpoint of fake prometheus. Responses are gzipped like in prometheus
+type fakePromServer struct {
+	*httptest.Server
+	t      *testing.T
+	series []fakeSeries
+
+	mu       sync.Mutex
+	hook     func(w http.ResponseWriter, r *http.Request) bool
+	received []url.Values
+}
+
+func fakePrometheus(t *testing.T, series ...fakeSeries) *fakePromServer {
+	p := &fakePromServer{t: t, series: series}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	return p
+}
+
+// upSeries returns series {__name__="up"} with values from query start
+func upSeries(values ...float64) fakeSeries {
+	return fakeSeries{labels: map[string]string{"__name__": "up"}, values: values}
+}
+
+// handle sets hook called before response. Response is not written if hook returns false
+func (p *fakePromServer) handle(hook func(w http.ResponseWriter, r *http.Request) bool) {
+	p.mu.Lock()
+	p.hook = hook
+	p.mu.Unlock()
+}
+
+// queries returns query parameters of all received requests
+func (p *fakePromServer) queries() []url.Values {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]url.Values(nil), p.received...)
+}
+
+func (p *fakePromServer) serve(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.received = append(p.received, r.URL.Query())
+	hook := p.hook
+	p.mu.Unlock()
+
+	if hook != nil && !hook(w, r) {
+		return
+	}
+
+	queryStart, _ := strconv.ParseFloat(r.URL.Query().Get("start"), 64)
+	queryStep, _ := strconv.ParseFloat(r.URL.Query().Get("step"), 64)
+
+	type matrixItem struct {
+		Metric map[string]string `json:"metric"`
+		Values [][2]interface{}  `json:"values"`
+	}
+	result := make([]matrixItem, 0, len(p.series))
+	for _, s := range p.series {
+		start, step := float64(s.start), float64(s.step)
+		if s.start == 0 {
+			start = queryStart
+		}
+		if s.step == 0 {
+			step = queryStep
+		}
+		item := matrixItem{Metric: s.labels, Values: make([][2]interface{}, 0, len(s.values))}
+		for i, v := range s.values {
+			if math.IsNaN(v) {
+				continue
+			}
+			item.Values = append(item.Values, [2]interface{}{start + float64(i)*step, strconv.FormatFloat(v, 'f', -1, 64)})
+		}
+		result = append(result, item)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"status": "success",
+		"data":   map[string]interface{}{"resultType": "matrix", "result": result},
+	})
+	if err != nil {
+		p.t.Error(err)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write(body)
+		zw.Close()
+		return
+	}
+	w.Write(body)
+}
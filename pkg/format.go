@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+var formatContentType = map[string]string{
+	"png":  "image/png",
+	"svg":  "image/svg+xml",
+	"pdf":  "application/pdf",
+	"jpeg": "image/jpeg",
+	"csv":  "text/csv",
+	"json": "application/json",
+	"raw":  "text/plain",
+	"txt":  "text/plain; charset=utf-8",
+	"ansi": "text/plain; charset=utf-8",
+}
+
+// negotiable formats in order of server preference with their media types
+var acceptFormats = []struct {
+	format     string
+	mediaTypes []string
+}{
+	{"png", []string{"image/png"}},
+	{"svg", []string{"image/svg+xml", "image/svg"}},
+	{"jpeg", []string{"image/jpeg"}},
+	{"pdf", []string{"application/pdf"}},
+	{"csv", []string{"text/csv"}},
+	{"json", []string{"application/json"}},
+	{"txt", []string{"text/plain"}},
+	{"ansi", []string{"text/x-ansi"}},
+}
+
+func normalizeFormat(format string) string {
+	format = strings.ToLower(format)
+	if format == "jpg" {
+		return "jpeg"
+	}
+	return format
+}
+
+// negotiateFormat selects output format by Accept header from formats accepted by supported.
+// Quality of format is taken from the most specific matching media range, formats with equal quality
+// are selected in server preference order. Returns empty string if nothing is acceptable
+func negotiateFormat(accept string, supported func(format string) bool) string {
+	// quality by media range
+	ranges := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaRange == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		ranges[mediaRange] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, f := range acceptFormats {
+		if !supported(f.format) {
+			continue
+		}
+		q := -1.0
+		for _, mediaType := range f.mediaTypes {
+			if v, ok := ranges[mediaType]; ok && v > q {
+				q = v
+			}
+		}
+		if q < 0 {
+			if v, ok := ranges[strings.SplitN(f.mediaTypes[0], "/", 2)[0]+"/*"]; ok {
+				q = v
+			} else if v, ok := ranges["*/*"]; ok {
+				q = v
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f.format, q
+		}
+	}
+	return best
+}
+
+func pngToJPEG(body []byte, quality int) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// pngToPDF wraps rendered picture to single page PDF document. Page size in points is picture size divided by pixelRatio
+func pngToPDF(body []byte, pixelRatio float64) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if pixelRatio <= 0 {
+		pixelRatio = 1
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	var pixels bytes.Buffer
+	zw := zlib.NewWriter(&pixels)
+	row := make([]byte, 3*width)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			i := 3 * (x - bounds.Min.X)
+			row[i], row[i+1], row[i+2] = byte(r>>8), byte(g>>8), byte(b>>8)
+		}
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	pageWidth := strconv.FormatFloat(float64(width)/pixelRatio, 'f', 2, 64)
+	pageHeight := strconv.FormatFloat(float64(height)/pixelRatio, 'f', 2, 64)
+	content := fmt.Sprintf("q %s 0 0 %s 0 0 cm /Im0 Do Q\n", pageWidth, pageHeight)
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /XObject << /Im0 4 0 R >> >> /Contents 5 0 R >>", pageWidth, pageHeight),
+		fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", width, height, pixels.Len(), pixels.String()),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	all := func(string) bool { return true }
+	goFormats := func(format string) bool { return renderFormat(&goRenderer{}, format) }
+
+	assert.Equal("", negotiateFormat("", all))
+	assert.Equal("", negotiateFormat("text/html", all))
+	assert.Equal("png", negotiateFormat("text/html,image/*;q=0.8", all))
+	assert.Equal("svg", negotiateFormat("image/png;q=0.5, image/svg+xml", all))
+	assert.Equal("png", negotiateFormat("image/png;q=0.5, image/svg+xml", goFormats))
+	assert.Equal("pdf", negotiateFormat("application/pdf", all))
+	assert.Equal("jpeg", negotiateFormat("image/jpeg;q=0.9, image/png;q=0", all))
+	assert.Equal("svg", negotiateFormat("image/png;q=0, image/*", all))
+	assert.Equal("jpeg", negotiateFormat("image/png;q=0, image/*", goFormats))
+	assert.Equal("txt", negotiateFormat("text/plain", all))
+	assert.Equal("ansi", negotiateFormat("text/plain;q=0.5, text/x-ansi", all))
+	assert.Equal("csv", negotiateFormat("text/csv;q=0.5, */*;q=0.1", all))
+
+	// browser <img> requests: svg and png have equal quality, png is preferred
+	chrome := "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+	firefox := "image/avif,image/webp,*/*"
+	assert.Equal("png", negotiateFormat(chrome, all))
+	assert.Equal("png", negotiateFormat(chrome, goFormats))
+	assert.Equal("png", negotiateFormat(firefox, goFormats))
+}
+
+func TestConvertPNG(t *testing.T) {
+	assert := assert.New(t)
+
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for x := 0; x < 20; x++ {
+		img.Set(x, 5, color.RGBA{255, 0, 0, 255})
+	}
+	var b bytes.Buffer
+	assert.NoError(png.Encode(&b, img))
+
+	body, err := pngToJPEG(b.Bytes(), 90)
+	assert.NoError(err)
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(body))
+	assert.NoError(err)
+	assert.Equal(20, cfg.Width)
+
+	body, err = pngToPDF(b.Bytes(), 2)
+	assert.NoError(err)
+	assert.True(bytes.HasPrefix(body, []byte("%PDF-1.4\n")))
+	assert.Contains(string(body), "/MediaBox [0 0 10.00 5.00]")
+	assert.Contains(string(body), "/Width 20 /Height 10")
+	assert.True(bytes.HasSuffix(body, []byte("%%EOF\n")))
+
+	_, err = pngToPDF([]byte("not png"), 1)
+	assert.Error(err)
+}
+
+func TestDataFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t,
+		fakeSeries{labels: map[string]string{"__name__": "up", "job": "a"}, values: []float64{1, 0}},
+		fakeSeries{labels: map[string]string{"__name__": "up", "job": "b"}, values: []float64{1, 1}},
+	)
+	defer prom.Close()
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+
+	// step is 60 with default width 330
+	const timeRange = "&from=1537555320&until=1537594920"
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g0.filter[job]=a&g0.legend={{.job}}&format=json"+timeRange, nil))
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.Equal(`[{"target":"a","datapoints":[[1,1537555320],[0,1537555380]]}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g0.filter[job]=a&g0.legend={{.job}}&format=raw"+timeRange, nil))
+	assert.Equal("a,1537555320,1537555380,60|1,0\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/?g0.expr=up&g0.legend={{.job}}"+timeRange, nil)
+	r.Header.Set("Accept", "text/csv")
+	h.ServeHTTP(w, r)
+	assert.Equal("text/csv", w.Header().Get("Content-Type"))
+	assert.Contains(w.Body.String(), `"b",`)
+}
+
+func TestBrowserAccept(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t, upSeries(1, 2, 3))
+	defer prom.Close()
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetRenderer(renderers["go"])
+
+	// Chrome <img>
+	r := httptest.NewRequest("GET", "/?g0.expr=up", nil)
+	r.Header.Set("Accept", "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(200, w.Code, w.Body.String())
+	assert.Equal("image/png", w.Header().Get("Content-Type"))
+
+	// go renderer can't draw svg
+	r = httptest.NewRequest("GET", "/?g0.expr=up", nil)
+	r.Header.Set("Accept", "image/svg+xml, image/png;q=0.5")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(200, w.Code, w.Body.String())
+	assert.Equal("image/png", w.Header().Get("Content-Type"))
+}
@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-graphite/carbonapi/pkg/parser"
+)
+
+var prometheusEndInputFormats = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	time.RFC3339,
+}
+
+func parsePrometheusEndInput(s string) (int64, error) {
+	if ts, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(ts), nil
+	}
+	for _, format := range prometheusEndInputFormats {
+		// prometheus UI shows end time in UTC
+		if t, err := time.ParseInLocation(format, s, time.UTC); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("can't parse end_input %#v", s)
+}
+
+// translatePrometheusURL converts link to prometheus /graph page to prometheus-png GET-parameters.
+// Range and end of the first graph are used for whole picture
+func translatePrometheusURL(rawURL string) (url.Values, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	promValues := u.Query()
+	values := url.Values{}
+	first := -1
+
+	for k, v := range promValues {
+		t := gNRegexp.FindStringSubmatch(k)
+		if len(t) == 0 || t[2] != "expr" || len(v) == 0 || v[0] == "" {
+			continue
+		}
+		values.Set(k, v[0])
+
+		graphID, err := strconv.Atoi(t[1])
+		if err != nil {
+			return nil, err
+		}
+		if first < 0 || graphID < first {
+			first = graphID
+		}
+	}
+
+	if first < 0 {
+		return nil, fmt.Errorf("g0.expr not found in %#v", rawURL)
+	}
+
+	prefix := fmt.Sprintf("g%d.", first)
+
+	rangeSeconds := int64(3600)
+	if rangeInput := promValues.Get(prefix + "range_input"); rangeInput != "" {
+		r, err := parser.IntervalString(rangeInput, 1)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse range_input %#v: %s", rangeInput, err.Error())
+		}
+		rangeSeconds = int64(r)
+	}
+
+	if endInput := promValues.Get(prefix + "end_input"); endInput != "" {
+		end, err := parsePrometheusEndInput(endInput)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("from", strconv.FormatInt(end-rangeSeconds, 10))
+		values.Set("until", strconv.FormatInt(end, 10))
+	} else {
+		values.Set("from", fmt.Sprintf("-%ds", rangeSeconds))
+	}
+
+	if stepInput := promValues.Get(prefix + "step_input"); stepInput != "" {
+		values.Set("step", stepInput)
+	}
+
+	if promValues.Get(prefix+"stacked") == "1" {
+		values.Set("areaMode", "stacked")
+	}
+
+	return values, nil
+}
+
+// FromPrometheus renders picture for link to prometheus /graph page passed in "url" parameter.
+// All other parameters are passed to png handler as is
+type FromPrometheus struct {
+	png *Handler
+}
+
+func NewFromPrometheus(png *Handler) *FromPrometheus {
+	return &FromPrometheus{png: png}
+}
+
+func (fp *FromPrometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	signer := fp.png.getState().signer
+	if signer != nil {
+		if err := signer.Verify(q); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	rawURL := q.Get("url")
+	if rawURL == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	q.Del("url")
+
+	values, err := translatePrometheusURL(rawURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for k, v := range q {
+		values[k] = v
+	}
+
+	// original url is verified, translated parameters are signed again with the same expiration
+	if signer != nil && values.Get("sig") != "" {
+		values.Set("sig", signer.signature(values))
+	}
+
+	r2 := r.WithContext(r.Context())
+	u := *r.URL
+	u.RawQuery = values.Encode()
+	r2.URL = &u
+	r2.Form = nil
+
+	fp.png.ServeHTTP(w, r2)
+}
@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslatePrometheusURL(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		url      string
+		expected url.Values
+	}{
+		{
+			"http://prometheus:9090/graph?g0.range_input=1h&g0.expr=up&g0.tab=0",
+			url.Values{"g0.expr": {"up"}, "from": {"-3600s"}},
+		},
+		{
+			"http://prometheus:9090/graph?g0.range_input=2d&g0.end_input=2018-09-21%2018%3A43&g0.expr=rate(x%5B5m%5D)&g0.stacked=1&g1.expr=y",
+			url.Values{"g0.expr": {"rate(x[5m])"}, "g1.expr": {"y"}, "from": {"1537382580"}, "until": {"1537555380"}, "areaMode": {"stacked"}},
+		},
+		{
+			"http://prometheus:9090/graph?g1.expr=up&g1.range_input=30m&g1.end_input=1537555380",
+			url.Values{"g1.expr": {"up"}, "from": {"1537553580"}, "until": {"1537555380"}},
+		},
+	}
+
+	for _, c := range table {
+		values, err := translatePrometheusURL(c.url)
+		assert.NoError(err)
+		assert.Equal(c.expected, values, c.url)
+	}
+
+	_, err := translatePrometheusURL("http://prometheus:9090/graph?g0.tab=1")
+	assert.Error(err)
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Graphite queries graphite-web or carbonapi /render?format=json endpoint.
+// Datapoints are averaged to query step, series labels are graphite tags and "__name__" with target name
+type Graphite struct {
+	addr string
+}
+
+func NewGraphite(addr string) *Graphite {
+	return &Graphite{addr: addr}
+}
+
+// Ping checks /version endpoint of graphite-web or carbonapi
+func (g *Graphite) Ping(ctx context.Context) error {
+	u, err := url.Parse(g.addr)
+	if err != nil {
+		return err
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/version"
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("graphite status: %s", res.Status)
+	}
+	return nil
+}
+
+type graphiteSeries struct {
+	Target     string            `json:"target"`
+	Tags       map[string]string `json:"tags"`
+	Datapoints [][2]*float64     `json:"datapoints"`
+}
+
+func (g *Graphite) QueryRange(ctx context.Context, expr string, start, end, step int64) ([]*Series, error) {
+	u, err := url.Parse(g.addr)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/render"
+
+	q := url.Values{}
+	q.Set("target", expr)
+	q.Set("from", strconv.FormatInt(start-step, 10))
+	q.Set("until", strconv.FormatInt(end, 10))
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("graphite status: %s", res.Status)
+	}
+
+	var response []graphiteSeries
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	result := make([]*Series, 0, len(response))
+	for _, gs := range response {
+		labels := make(map[string]string)
+		for k, v := range gs.Tags {
+			labels[k] = v
+		}
+		labels["__name__"] = gs.Target
+
+		c := newConsolidatedSeries(start, end, step)
+		for _, p := range gs.Datapoints {
+			if p[0] == nil || p[1] == nil {
+				continue
+			}
+			c.add(*p[1], *p[0])
+		}
+		if s := c.series(labels); s != nil {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
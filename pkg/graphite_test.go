@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphite(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/graphite/render", r.URL.Path)
+		assert.Equal("json", r.URL.Query().Get("format"))
+		assert.Equal("940", r.URL.Query().Get("from"))
+		w.Write([]byte(`[{"target":"servers.a.cpu","tags":{"name":"servers.a.cpu"},"datapoints":` +
+			`[[1,950],[3,1000],[null,1010],[4,1030],[6,1060],[7,1200],[100,1400]]}]`))
+	}))
+	defer srv.Close()
+
+	result, err := NewGraphite(srv.URL+"/graphite/").QueryRange(context.Background(), "servers.*.cpu", 1000, 1300, 60)
+	assert.NoError(err)
+	if assert.Len(result, 1) {
+		s := result[0]
+		assert.Equal("servers.a.cpu", s.Labels["__name__"])
+		assert.Equal(int64(1000), s.StartTime)
+		assert.Equal(int64(1240), s.StopTime)
+		if assert.Len(s.Values, 5) {
+			assert.Equal(2.0, s.Values[0])
+			assert.Equal(5.0, s.Values[1])
+			assert.True(math.IsNaN(s.Values[2]))
+			assert.Equal(7.0, s.Values[4])
+		}
+	}
+}
+
+func TestMixedDatasources(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t, upSeries(1))
+	defer prom.Close()
+
+	graphite := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"target":"legacy.up","datapoints":[[2,1537555344]]}]`))
+	}))
+	defer graphite.Close()
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetDatasource("graphite", NewGraphite(graphite.URL))
+
+	policy, err := NewPolicy(PolicyOptions{MetricAllow: []string{"up"}})
+	assert.NoError(err)
+	h.SetPolicy(policy)
+
+	// policy rules can't check graphite targets
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g1.expr=secret.metric&g1.ds=graphite&from=1537555344&until=1537594944&format=csv", nil))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	policy, err = NewPolicy(PolicyOptions{MetricAllow: []string{"up"}, DatasourceAllow: []string{"graphite"}})
+	assert.NoError(err)
+	h.SetPolicy(policy)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g1.expr=legacy.up&g1.ds=graphite&from=1537555344&until=1537594944&format=csv", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(body, `"up",`)
+	assert.True(strings.Index(body, `"up",`) < strings.Index(body, `"legacy.up",`), body)
+}
@@ -3,20 +3,19 @@ package pkg
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/go-graphite/carbonapi/date"
-	"github.com/go-graphite/carbonapi/expr/functions/cairo/png"
 	"github.com/go-graphite/carbonapi/expr/types"
 	pb "github.com/go-graphite/protocol/carbonapi_v3_pb"
 )
@@ -27,18 +26,194 @@ var gNRegexp = regexp.MustCompile("^g([0-9]+)[.](.*?)$")
 
 type Handler struct {
 	defaultTimeZone *time.Location
-	promAddr        string
-	queryRangePath  string
 	defaultTimeout  time.Duration
+	renderer        Renderer
+	cache           *Cache
+	logger          *AccessLogger
+	fetchLimiter    *Limiter
+	renderLimiter   *Limiter
+
+	stateMu sync.RWMutex
+	state   *handlerState
+}
+
+// handlerState is reloadable configuration. It is replaced as a whole and never modified,
+// request uses single snapshot
+type handlerState struct {
+	signer      *Signer
+	policy      *Policy
+	apiKeys     *APIKeys
+	datasources map[string]Datasource
+	minSteps    map[string]int64
 }
 
 func NewPNG(promAddr string, queryRangePath string, defaultTimeout time.Duration) *Handler {
 	return &Handler{
 		defaultTimeZone: time.Local,
-		promAddr:        promAddr,
-		queryRangePath:  queryRangePath,
 		defaultTimeout:  defaultTimeout,
+		renderer:        renderers[defaultRenderer],
+		state: &handlerState{
+			datasources: map[string]Datasource{
+				"default": NewPrometheus(promAddr, queryRangePath),
+				"static":  NewStatic(""),
+			},
+			minSteps: make(map[string]int64),
+		},
+	}
+}
+
+func (h *Handler) getState() *handlerState {
+	h.stateMu.RLock()
+	defer h.stateMu.RUnlock()
+	return h.state
+}
+
+// updateState applies fn to copy of current state and replaces it
+func (h *Handler) updateState(fn func(s *handlerState)) {
+	h.stateMu.Lock()
+	defer h.stateMu.Unlock()
+	s := *h.state
+	s.datasources = make(map[string]Datasource, len(h.state.datasources))
+	for k, v := range h.state.datasources {
+		s.datasources[k] = v
+	}
+	s.minSteps = make(map[string]int64, len(h.state.minSteps))
+	for k, v := range h.state.minSteps {
+		s.minSteps[k] = v
+	}
+	fn(&s)
+	h.state = &s
+}
+
+func (h *Handler) SetRenderer(renderer Renderer) {
+	h.renderer = renderer
+}
+
+func (h *Handler) SetCache(cache *Cache) {
+	h.cache = cache
+}
+
+func (h *Handler) SetLogger(logger *AccessLogger) {
+	h.logger = logger
+}
+
+func (h *Handler) SetSigner(signer *Signer) {
+	h.updateState(func(s *handlerState) { s.signer = signer })
+}
+
+func (h *Handler) SetPolicy(policy *Policy) {
+	h.updateState(func(s *handlerState) { s.policy = policy })
+}
+
+func (h *Handler) SetAPIKeys(apiKeys *APIKeys) {
+	h.updateState(func(s *handlerState) { s.apiKeys = apiKeys })
+}
+
+// SetLimiters sets concurrency limits of upstream queries and picture rendering. nil is unlimited
+func (h *Handler) SetLimiters(fetch *Limiter, render *Limiter) {
+	h.fetchLimiter = fetch
+	h.renderLimiter = render
+}
+
+// SetDatasource adds datasource available as gN.ds=name. "default" replaces prometheus from NewPNG
+func (h *Handler) SetDatasource(name string, ds Datasource) {
+	h.updateState(func(s *handlerState) { s.datasources[name] = ds })
+}
+
+// SetMinStep sets lower bound of query step of datasource, usually its scrape interval
+func (h *Handler) SetMinStep(datasource string, step time.Duration) {
+	h.updateState(func(s *handlerState) { s.minSteps[datasource] = int64(step / time.Second) })
+}
+
+// HandlerOptions is reloadable part of handler configuration
+type HandlerOptions struct {
+	Signer  *Signer
+	Policy  *Policy
+	APIKeys *APIKeys
+	// datasources in addition to "default" prometheus from NewPNG
+	Datasources map[string]Datasource
+	MinSteps    map[string]time.Duration
+	// nil keeps current templates
+	Templates map[string]url.Values
+}
+
+// Configure replaces signer, policy, api keys, datasources and templates at once.
+// Running requests finish with previous configuration
+func (h *Handler) Configure(o HandlerOptions) {
+	state := &handlerState{
+		signer:      o.Signer,
+		policy:      o.Policy,
+		apiKeys:     o.APIKeys,
+		datasources: map[string]Datasource{"static": NewStatic("")},
+		minSteps:    make(map[string]int64),
+	}
+	for name, ds := range o.Datasources {
+		state.datasources[name] = ds
+	}
+	for name, step := range o.MinSteps {
+		state.minSteps[name] = int64(step / time.Second)
+	}
+
+	h.stateMu.Lock()
+	defer h.stateMu.Unlock()
+	if _, exists := state.datasources["default"]; !exists {
+		state.datasources["default"] = h.state.datasources["default"]
+	}
+	templatesMutex.Lock()
+	defer templatesMutex.Unlock()
+	h.state = state
+	if o.Templates != nil {
+		templates = o.Templates
+	}
+}
+
+// checkAccess checks template and datasource allow-lists of api key and query policy
+func (h *Handler) checkAccess(w http.ResponseWriter, params *renderParams, key *apiKey) bool {
+	policy := params.state.policy
+	if key != nil {
+		if !key.allowTemplate(params.Template) {
+			http.Error(w, fmt.Sprintf("template %#v is not allowed", params.Template), http.StatusForbidden)
+			return false
+		}
+		for _, g := range params.G {
+			if !key.allowDatasource(g.datasource()) {
+				http.Error(w, fmt.Sprintf("datasource %#v is not allowed", g.datasource()), http.StatusForbidden)
+				return false
+			}
+		}
+		if key.policy != nil {
+			policy = key.policy
+		}
+	}
+	if policy == nil {
+		return true
+	}
+	for _, g := range params.G {
+		// policy rules are PromQL only, other datasources should be allowed explicitly
+		switch params.state.datasources[g.datasource()].(type) {
+		case *Graphite, *Static:
+			if err := policy.CheckDatasource(g.datasource()); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return false
+			}
+			continue
+		}
+		if err := policy.Check(g.Expr); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", g.Expr, err), http.StatusForbidden)
+			return false
+		}
 	}
+	return true
+}
+
+// Ping checks readiness of default datasource if it supports it
+func (h *Handler) Ping(ctx context.Context) error {
+	if p, ok := h.getState().datasources["default"].(interface {
+		Ping(ctx context.Context) error
+	}); ok {
+		return p.Ping(ctx)
+	}
+	return nil
 }
 
 func formatLegend(nameMap map[string]string, tpl *template.Template) string {
@@ -68,30 +243,75 @@ func formatLegend(nameMap map[string]string, tpl *template.Template) string {
 	return "{}"
 }
 
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	type G struct {
-		Expr     string            `form:"expr"`
-		Legend   string            `form:"legend"`
-		Filter   map[string]string `form:"filter"`
-		Template *template.Template
-	}
-	params := struct {
-		G        map[int]*G    `form:"-"`
-		Query    string        `form:"query"`
-		From     string        `form:"from"`
-		Until    string        `form:"until"`
-		TZ       string        `form:"tz"`
-		Timeout  time.Duration `form:"timeout"`
-		Template string        `form:"template"`
-		Format   string        `form:"format"`
-	}{
+type graphParams struct {
+	Expr       string            `form:"expr"`
+	Legend     string            `form:"legend"`
+	Filter     map[string]string `form:"filter"`
+	Datasource string            `form:"ds"`
+	Data       string            `form:"data"`
+	Template   *template.Template
+
+	from  int64
+	until int64
+	step  int64
+}
+
+func (g *graphParams) datasource() string {
+	if g.Datasource == "" {
+		return "default"
+	}
+	return g.Datasource
+}
+
+type renderParams struct {
+	G        map[int]*graphParams `form:"-"`
+	Query    string               `form:"query"`
+	From     string               `form:"from"`
+	Until    string               `form:"until"`
+	TZ       string               `form:"tz"`
+	Timeout  time.Duration        `form:"timeout"`
+	Template string               `form:"template"`
+	Format   string               `form:"format"`
+	Quality  int                  `form:"quality"`
+	Step     string               `form:"step"`
+	MinStep  string               `form:"minStep"`
+	// fraction of automatic number of points, e.g. 1/2
+	Resolution string `form:"resolution"`
+
+	from  int64
+	until int64
+	step  int64
+	state *handlerState
+}
+
+func (h *Handler) parseRenderParams(w http.ResponseWriter, r *http.Request, state *handlerState) (*renderParams, bool) {
+	params := &renderParams{
 		Timeout: h.defaultTimeout,
-		G:       map[int]*G{},
-		Format:  "png",
+		G:       map[int]*graphParams{},
+		Quality: 85,
+		state:   state,
 	}
 
-	if !parseGetRequest(w, r, &params) {
-		return
+	if !parseGetRequest(w, r, params) {
+		return nil, false
+	}
+
+	if params.Format == "" {
+		params.Format = negotiateFormat(r.Header.Get("Accept"), func(format string) bool {
+			return renderFormat(h.renderer, format)
+		})
+		if params.Format == "" {
+			params.Format = "png"
+		}
+	}
+	params.Format = normalizeFormat(params.Format)
+	if _, ok := formatContentType[params.Format]; !ok {
+		http.Error(w, fmt.Sprintf("unsupported format %#v", params.Format), http.StatusBadRequest)
+		return nil, false
+	}
+	if params.Quality < 1 || params.Quality > 100 {
+		http.Error(w, "quality should be in range 1..100", http.StatusBadRequest)
+		return nil, false
 	}
 
 	gValues := make(map[int]url.Values)
@@ -102,7 +322,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			graphID, err := strconv.Atoi(t[1])
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+				return nil, false
 			}
 			d, exists := gValues[graphID]
 			if !exists {
@@ -114,19 +334,37 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for k, values := range gValues {
-		g := &G{}
+		g := &graphParams{}
 		if err := formDecoder.Decode(g, values); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return nil, false
+		}
+		if g.Data != "" {
+			if g.Expr != "" || g.Datasource != "" {
+				http.Error(w, fmt.Sprintf("g%d.data can't be used with expr or ds", k), http.StatusBadRequest)
+				return nil, false
+			}
+			if staticFile(g.Data) == "" {
+				if _, err := parseStaticData(g.Data); err != nil {
+					http.Error(w, fmt.Sprintf("g%d.data: %s", k, err), http.StatusBadRequest)
+					return nil, false
+				}
+			}
+			g.Expr = g.Data
+			g.Datasource = "static"
 		}
 		if g.Expr == "" {
 			continue
 		}
+		if _, ok := state.datasources[g.datasource()]; !ok {
+			http.Error(w, fmt.Sprintf("unknown datasource %#v", g.Datasource), http.StatusBadRequest)
+			return nil, false
+		}
 		if g.Legend != "" {
 			t, err := template.New("legend").Parse(g.Legend)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+				return nil, false
 			}
 			g.Template = t
 		}
@@ -135,30 +373,95 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if len(params.G) < 1 {
 		http.Error(w, "g0.expr is required", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
-	draftPictureParams := png.GetPictureParams(r, nil)
+	width := getFloat64(pictureValues(params.Template, r.URL.Query()), "width", 330)
 
-	ctx, cancel := context.WithTimeout(r.Context(), params.Timeout)
-	defer cancel()
+	params.from = date.DateParamToEpoch(params.From, params.TZ, timeNow().Add(-24*time.Hour).Unix(), h.defaultTimeZone)
+	params.until = date.DateParamToEpoch(params.Until, params.TZ, timeNow().Unix(), h.defaultTimeZone)
+
+	if params.until < params.from {
+		http.Error(w, "until should be after from", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if params.Step != "" {
+		step, err := parseStep(params.Step)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		params.step = step
+	} else {
+		resolution := 1.0
+		if params.Resolution != "" {
+			r, err := parseResolution(params.Resolution)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return nil, false
+			}
+			resolution = r
+		}
+		params.step = int64(float64(params.until-params.from) / (2 * width * resolution))
+	}
+	if params.MinStep != "" {
+		minStep, err := parseStep(params.MinStep)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		if params.step < minStep {
+			params.step = minStep
+		}
+	}
+	if params.step < 1 {
+		params.step = 1
+	}
 
-	from32 := date.DateParamToEpoch(params.From, params.TZ, timeNow().Add(-24*time.Hour).Unix(), h.defaultTimeZone)
-	until32 := date.DateParamToEpoch(params.Until, params.TZ, timeNow().Unix(), h.defaultTimeZone)
+	for _, g := range params.G {
+		minStep := state.minSteps[g.datasource()]
+		g.step = params.step
+		if g.step < minStep {
+			g.step = minStep
+		}
+		// range aligned to multiple of step returns the same points while time is moving
+		g.from = params.from / g.step * g.step
+		g.until = params.until / g.step * g.step
+		if (g.until-g.from)/g.step+1 > maxPoints {
+			http.Error(w, fmt.Sprintf("exceeded maximum resolution of %d points per series, increase step or decrease resolution", maxPoints), http.StatusBadRequest)
+			return nil, false
+		}
 
-	step := (until32 - from32) / int64(2*draftPictureParams.Width)
-	if step < 1 {
-		step = 1
+		scrapeInterval := minStep
+		if scrapeInterval == 0 {
+			scrapeInterval = defaultScrapeInterval
+		}
+		g.Expr = interpolateIntervals(g.Expr, g.step, scrapeInterval)
 	}
 
-	metricData := make([]*types.MetricData, 0)
+	return params, true
+}
 
-	u, err := url.Parse(h.promAddr)
+// queryRange returns series from datasource or error with http status
+func (h *Handler) queryRange(ctx context.Context, state *handlerState, g *graphParams) ([]*Series, int, error) {
+	if err := h.fetchLimiter.Acquire(ctx); err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+	defer h.fetchLimiter.Release()
+
+	start := time.Now()
+	result, err := state.datasources[g.datasource()].QueryRange(ctx, g.Expr, g.from, g.until, g.step)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		metrics.upstreamLatency.Observe(time.Since(start).Seconds(), g.datasource(), "error")
+		return nil, http.StatusBadGateway, err
 	}
-	u.Path = h.queryRangePath
+	metrics.upstreamLatency.Observe(time.Since(start).Seconds(), g.datasource(), "ok")
+	return result, http.StatusOK, nil
+}
+
+func (h *Handler) fetch(ctx context.Context, w http.ResponseWriter, params *renderParams) ([]*types.MetricData, bool) {
+	metricData := make([]*types.MetricData, 0)
 
 	indexes := make([]int, 0, len(params.G))
 	for index, _ := range params.G {
@@ -166,77 +469,72 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	sort.Ints(indexes)
 
+	rec := accessRecordFrom(ctx)
+
 	for _, index := range indexes {
 		graphData := params.G[index]
-		q := u.Query()
-		q.Set("query", graphData.Expr)
-		q.Set("start", strconv.Itoa(int(from32)))
-		q.Set("end", strconv.Itoa(int(until32)))
-		q.Set("step", strconv.Itoa(int(step)))
-		u.RawQuery = q.Encode()
-
-		req, err := http.NewRequest("GET", u.String(), nil)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
 
-		res, err := http.DefaultClient.Do(req.WithContext(ctx))
+		queryStart := time.Now()
+		result, status, err := h.queryRange(ctx, params.state, graphData)
+		queryDuration := time.Since(queryStart)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-			return
+			rec.addQuery(upstreamQueryLog{Expr: graphData.Expr, Duration: queryDuration.Seconds(), Error: err.Error()})
+			http.Error(w, err.Error(), status)
+			return nil, false
 		}
 
-		if res.StatusCode != 200 {
-			http.Error(w, fmt.Sprintf("prometheus status: %s", res.Status), http.StatusBadGateway)
-			return
-		}
-
-		promBody, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-			return
-		}
-
-		promRes := &PrometheusResponse{}
-		err = json.Unmarshal(promBody, promRes)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		seriesBefore := len(metricData)
 
 	SeriesLoop:
-		for _, r := range promRes.Data.Result {
-			if len(r.Values) < 1 {
-				continue
-			}
+		for _, s := range result {
 			// check filter
 			for labelName, filterValue := range graphData.Filter {
-				if labelValue, exists := r.Metric[labelName]; !exists || filterValue != labelValue {
+				if labelValue, exists := s.Labels[labelName]; !exists || filterValue != labelValue {
 					continue SeriesLoop
 				}
 			}
 
-			step := int64(1)
-			if len(r.Values) > 1 {
-				step = r.Values[1].Timestamp - r.Values[0].Timestamp
-			}
-			md := &types.MetricData{
-				FetchResponse: pb.FetchResponse{
-					Name:              formatLegend(r.Metric, graphData.Template),
-					StartTime:         r.Values[0].Timestamp,
-					StopTime:          r.Values[len(r.Values)-1].Timestamp,
-					StepTime:          step,
-					Values:            make([]float64, len(r.Values)),
-					ConsolidationFunc: "average",
-				},
-				ValuesPerPoint: 1,
-			}
-			for i, v := range r.Values {
-				md.FetchResponse.Values[i] = v.Value
-			}
-			metricData = append(metricData, md)
+			s.Name = formatLegend(s.Labels, graphData.Template)
+			metricData = append(metricData, s.MetricData)
+		}
+
+		rec.addQuery(upstreamQueryLog{Expr: graphData.Expr, Duration: queryDuration.Seconds(), Series: len(metricData) - seriesBefore})
+	}
+
+	return metricData, true
+}
+
+func (h *Handler) render(r *http.Request, params *renderParams, metricData []*types.MetricData) ([]byte, string, error) {
+	contentType := formatContentType[params.Format]
+
+	switch params.Format {
+	case "csv":
+		return types.MarshalCSV(metricData), contentType, nil
+	case "json":
+		return types.MarshalJSON(metricData), contentType, nil
+	case "raw":
+		return types.MarshalRaw(metricData), contentType, nil
+	}
+
+	values := pictureValues(params.Template, r.URL.Query())
+
+	switch params.Format {
+	case "txt", "ansi":
+		textParams := TextParams{
+			Width:     80,
+			Height:    20,
+			Title:     values.Get("title"),
+			ColorList: getColorList(values),
+			Tz:        getTimeZone(values),
+			ANSI:      params.Format == "ansi",
+		}
+		if width, err := strconv.Atoi(r.URL.Query().Get("width")); err == nil && width > 0 {
+			textParams.Width = width
+		}
+		if height, err := strconv.Atoi(r.URL.Query().Get("height")); err == nil && height > 0 {
+			textParams.Height = height
 		}
+		return MarshalText(textParams, metricData), contentType, nil
 	}
 
 	if len(metricData) == 0 {
@@ -249,16 +547,184 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			ValuesPerPoint: 1,
 		})
 	}
-	pictureParams := png.GetPictureParamsWithTemplate(r, params.Template, metricData)
 
-	var response []byte
+	if err := h.renderLimiter.Acquire(r.Context()); err != nil {
+		return nil, contentType, err
+	}
+	defer h.renderLimiter.Release()
 
-	if params.Format == "svg" {
-		response = png.MarshalSVG(pictureParams, metricData)
-		w.Header().Set("Content-Type", "image/svg")
-	} else {
-		response = png.MarshalPNG(pictureParams, metricData)
-		w.Header().Set("Content-Type", "image/png")
+	body, err := h.renderer.Render(values, metricData, params.Format)
+	return body, contentType, err
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, params *renderParams) {
+	ctx, cancel := context.WithTimeout(r.Context(), params.Timeout)
+	defer cancel()
+
+	metricData, ok := h.fetch(ctx, w, params)
+	if !ok {
+		return
+	}
+
+	points := 0
+	for _, md := range metricData {
+		points += len(md.Values)
+	}
+	metrics.series.Observe(float64(len(metricData)))
+	metrics.points.Observe(float64(points))
+
+	renderStart := time.Now()
+	response, contentType, err := h.render(r.WithContext(ctx), params, metricData)
+	metrics.renderDuration.Observe(time.Since(renderStart).Seconds(), params.Format)
+	accessRecordFrom(ctx).setRender(time.Since(renderStart))
+	if isLimiterError(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
 	w.Write(response)
 }
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+	errMsg []byte
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status >= 400 && len(sw.errMsg) < 1024 {
+		sw.errMsg = append(sw.errMsg, b...)
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.size += n
+	return n, err
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+	// label values are validated to keep metrics cardinality low
+	format, templateName := "", "default"
+	defer func() {
+		metrics.requests.Inc(format, templateName, strconv.Itoa(w.status))
+	}()
+
+	var rec *accessRecord
+	if h.logger != nil {
+		start := time.Now()
+		rec = h.logger.start(r)
+		r = r.WithContext(withAccessRecord(r.Context(), rec))
+		defer func() {
+			rec.Status = w.status
+			rec.Size = w.size
+			rec.Error = strings.TrimSpace(string(w.errMsg))
+			h.logger.finish(rec, start)
+		}()
+	}
+
+	state := h.getState()
+
+	if state.signer != nil {
+		if err := state.signer.Verify(r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	var key *apiKey
+	if state.apiKeys != nil {
+		var ok bool
+		if key, ok = state.apiKeys.authenticate(w, r); !ok {
+			return
+		}
+	}
+
+	params, ok := h.parseRenderParams(w, r, state)
+	if !ok {
+		return
+	}
+	if !h.checkAccess(w, params, key) {
+		return
+	}
+	format = params.Format
+	if templateExists(params.Template) {
+		templateName = params.Template
+	}
+	if rec != nil {
+		rec.Format = params.Format
+		rec.Template = params.Template
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	render := func(r *http.Request) *cachedResponse {
+		rec := httptest.NewRecorder()
+		h.serve(rec, r, params)
+		return &cachedResponse{
+			status:      rec.Code,
+			contentType: rec.Header().Get("Content-Type"),
+			body:        rec.Body.Bytes(),
+		}
+	}
+
+	var response *cachedResponse
+	if h.cache == nil {
+		response = render(r)
+	} else {
+		// shared render of collapsed requests is not canceled with request of the first client
+		detached := r.WithContext(withAccessRecord(context.Background(), rec))
+		var hit bool
+		response, hit = h.cache.Do(cacheKey(r.URL.Query(), params), func() *cachedResponse {
+			return render(detached)
+		})
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+			metrics.cacheRequests.Inc("hit")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+			metrics.cacheRequests.Inc("miss")
+		}
+		if rec != nil {
+			rec.Cache = strings.ToLower(w.Header().Get("X-Cache"))
+		}
+	}
+
+	w.Header().Set("Content-Type", response.contentType)
+
+	body := response.body
+	if response.status == http.StatusOK {
+		etag := responseETag(response.body)
+		if compressibleFormats[params.Format] {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				if gz := response.gzipped(); gz != nil {
+					body = gz
+					// encoded representation has own etag
+					etag = etag[:len(etag)-1] + `-gzip"`
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+			}
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", cacheControl(params))
+		if params.state.apiKeys != nil {
+			w.Header().Add("Vary", params.state.apiKeys.header)
+		}
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.WriteHeader(response.status)
+	w.Write(body)
+}
@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-graphite/carbonapi/date"
+)
+
+// isAbsoluteTime reports whether from/until parameter does not depend on current time:
+// epoch seconds or full date of carbonapi date.TimeFormats with optional time ("15:00_20180921")
+func isAbsoluteTime(s string) bool {
+	// carbonapi parses shorter numbers as dates
+	if _, err := strconv.ParseUint(s, 10, 64); err == nil && len(s) > 8 {
+		return true
+	}
+	fields := strings.Fields(strings.Replace(s, "_", " ", 1))
+	if len(fields) == 0 || len(fields) > 2 {
+		return false
+	}
+	ds := fields[len(fields)-1]
+	for _, format := range date.TimeFormats {
+		if _, err := time.Parse(format, ds); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheControl returns Cache-Control header value. Picture of relative time range is valid for one step,
+// picture of absolute time range in the past never changes. Response to api key must not be stored by shared caches
+func cacheControl(params *renderParams) string {
+	scope := "public"
+	if params.state != nil && params.state.apiKeys != nil {
+		scope = "private"
+	}
+	if isAbsoluteTime(params.From) && isAbsoluteTime(params.Until) && params.until < timeNow().Unix() {
+		return scope + ", max-age=31536000, immutable"
+	}
+	return fmt.Sprintf("%s, max-age=%d", scope, params.step)
+}
+
+func responseETag(body []byte) string {
+	h := sha1.Sum(body)
+	return `"` + hex.EncodeToString(h[:]) + `"`
+}
+
+func etagMatch(ifNoneMatch string, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
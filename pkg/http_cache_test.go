@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPCacheHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t, upSeries(1, 0))
+	defer prom.Close()
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&format=json&from=-1h&width=360", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("public, max-age=5", w.Header().Get("Cache-Control"))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(etag)
+
+	r := httptest.NewRequest("GET", "/?g0.expr=up&format=json&from=-1h&width=360", nil)
+	r.Header.Set("If-None-Match", `"other", `+etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(http.StatusNotModified, w.Code)
+	assert.Equal(0, w.Body.Len())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&format=json&from=1537555200&until=1537558800", nil))
+	assert.Equal("public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+
+	assert.False(isAbsoluteTime(""))
+	assert.False(isAbsoluteTime("now"))
+	assert.False(isAbsoluteTime("-1d"))
+	assert.False(isAbsoluteTime("midnight_yesterday"))
+	assert.True(isAbsoluteTime("20180921"))
+	assert.True(isAbsoluteTime("1537555200"))
+	assert.True(isAbsoluteTime("09/21/18"))
+	assert.True(isAbsoluteTime("15:00_20180921"))
+	assert.False(isAbsoluteTime("15:00"))
+	assert.False(isAbsoluteTime("3pm"))
+	assert.False(isAbsoluteTime("noon"))
+	assert.False(isAbsoluteTime("15:00_today"))
+	assert.False(isAbsoluteTime("2018"))
+}
+
+func TestHTTPCacheAPIKey(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t, upSeries(1, 0))
+	defer prom.Close()
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetAPIKeys(NewAPIKeys("", []APIKeyOptions{{Key: "secret", Name: "team"}}))
+
+	r := httptest.NewRequest("GET", "/?g0.expr=up&format=json&from=-1h&width=360", nil)
+	r.Header.Set("X-API-Key", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("private, max-age=5", w.Header().Get("Cache-Control"))
+	assert.Contains(w.Header()["Vary"], "X-API-Key")
+
+	r = httptest.NewRequest("GET", "/?g0.expr=up&format=json&from=1537555200&until=1537558800", nil)
+	r.Header.Set("X-API-Key", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal("private, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errQueueFull    = errors.New("server is overloaded, queue is full")
+	errQueueTimeout = errors.New("server is overloaded, queue timeout")
+)
+
+func isLimiterError(err error) bool {
+	return err == errQueueFull || err == errQueueTimeout
+}
+
+// Limiter bounds number of concurrent operations. Waiting operations are queued,
+// operation fails if queue is full or wait is longer than timeout
+type Limiter struct {
+	name      string
+	slots     chan struct{}
+	queueSize int32
+	waiting   int32
+	timeout   time.Duration
+}
+
+func NewLimiter(name string, concurrency int, queueSize int, timeout time.Duration) *Limiter {
+	return &Limiter{
+		name:      name,
+		slots:     make(chan struct{}, concurrency),
+		queueSize: int32(queueSize),
+		timeout:   timeout,
+	}
+}
+
+// Acquire waits for free slot. Release should be called if error is nil.
+// nil Limiter doesn't limit anything
+func (l *Limiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		metrics.queueWait.Observe(0, l.name)
+		return nil
+	default:
+	}
+
+	if atomic.AddInt32(&l.waiting, 1) > l.queueSize {
+		atomic.AddInt32(&l.waiting, -1)
+		metrics.queueRejected.Inc(l.name, "full")
+		return errQueueFull
+	}
+	defer atomic.AddInt32(&l.waiting, -1)
+
+	start := time.Now()
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		metrics.queueWait.Observe(time.Since(start).Seconds(), l.name)
+		return nil
+	case <-timer.C:
+		metrics.queueRejected.Inc(l.name, "timeout")
+		return errQueueTimeout
+	case <-ctx.Done():
+		metrics.queueRejected.Inc(l.name, "canceled")
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) Release() {
+	if l != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	l := NewLimiter("test", 1, 1, 50*time.Millisecond)
+	assert.NoError(l.Acquire(ctx))
+
+	// second waits in queue, third is rejected
+	done := make(chan error)
+	go func() { done <- l.Acquire(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(errQueueFull, l.Acquire(ctx))
+	assert.Equal(errQueueTimeout, <-done)
+
+	go func() { done <- l.Acquire(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	l.Release()
+	assert.NoError(<-done)
+	l.Release()
+
+	var nilLimiter *Limiter
+	assert.NoError(nilLimiter.Acquire(ctx))
+	nilLimiter.Release()
+}
+
+func TestLimiterRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t)
+	defer prom.Close()
+
+	fetch := NewLimiter("fetch", 1, 0, time.Millisecond)
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetLimiters(fetch, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g1.expr=up&format=json", nil))
+	assert.Equal(http.StatusOK, w.Code)
+
+	fetch.Acquire(context.Background())
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&format=json", nil))
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	fetch.Release()
+}
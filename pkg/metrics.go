@@ -0,0 +1,217 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal implementation of prometheus counters and histograms with text exposition format
+
+type metricLabels []string
+
+func (l metricLabels) key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func (l metricLabels) format(key string, extra ...string) string {
+	var values []string
+	if key != "" || len(l) > 0 {
+		values = strings.Split(key, "\xff")
+	}
+	pairs := make([]string, 0, len(l)+1)
+	for i, name := range l {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, strconv.Quote(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", extra[i], strconv.Quote(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type counterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels metricLabels
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	c.values[c.labels.key(labelValues)] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *counterVec) write(b *bytes.Buffer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s%s %s\n", c.name, c.labels.format(k), formatMetricValue(c.values[k]))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type histogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	labels  metricLabels
+	buckets []float64
+	values  map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) Observe(v float64, labelValues ...string) {
+	key := h.labels.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, exists := h.values[key]
+	if !exists {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) write(b *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hist := h.values[k]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, h.labels.format(k, "le", formatMetricValue(upper)), hist.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, h.labels.format(k, "le", "+Inf"), hist.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.name, h.labels.format(k), formatMetricValue(hist.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.name, h.labels.format(k), hist.count)
+	}
+}
+
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func (g *gaugeFunc) write(b *bytes.Buffer) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatMetricValue(g.fn()))
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+var countBuckets = []float64{1, 10, 100, 1000, 10000, 100000, 1000000}
+
+var metrics = struct {
+	requests        *counterVec
+	upstreamLatency *histogramVec
+	renderDuration  *histogramVec
+	series          *histogramVec
+	points          *histogramVec
+	cacheRequests   *counterVec
+	queueWait       *histogramVec
+	queueRejected   *counterVec
+}{
+	requests:        newCounterVec("prometheus_png_requests_total", "Render requests by format, template and response status.", "format", "template", "status"),
+	upstreamLatency: newHistogramVec("prometheus_png_upstream_duration_seconds", "Upstream query latency by datasource name and result.", durationBuckets, "datasource", "status"),
+	renderDuration:  newHistogramVec("prometheus_png_render_duration_seconds", "Render duration by format.", durationBuckets, "format"),
+	series:          newHistogramVec("prometheus_png_request_series", "Series per request.", countBuckets),
+	points:          newHistogramVec("prometheus_png_request_points", "Points per request.", countBuckets),
+	cacheRequests:   newCounterVec("prometheus_png_cache_requests_total", "Cache lookups by result.", "result"),
+	queueWait:       newHistogramVec("prometheus_png_queue_wait_seconds", "Time spent in fetch or render queue.", durationBuckets, "pool"),
+	queueRejected:   newCounterVec("prometheus_png_queue_rejected_total", "Operations rejected by fetch or render queue.", "pool", "reason"),
+}
+
+// Metrics exposes internal metrics in prometheus text format
+type Metrics struct {
+	gauges []*gaugeFunc
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// AddCache adds gauges with cache size
+func (m *Metrics) AddCache(cache *Cache) {
+	m.gauges = append(m.gauges,
+		&gaugeFunc{name: "prometheus_png_cache_items", help: "Responses in memory cache.", fn: func() float64 {
+			items, _ := cache.Stats()
+			return float64(items)
+		}},
+		&gaugeFunc{name: "prometheus_png_cache_size_bytes", help: "Size of responses in memory cache.", fn: func() float64 {
+			_, size := cache.Stats()
+			return float64(size)
+		}},
+	)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+
+	metrics.requests.write(&b)
+	metrics.upstreamLatency.write(&b)
+	metrics.renderDuration.write(&b)
+	metrics.series.write(&b)
+	metrics.points.write(&b)
+	metrics.cacheRequests.write(&b)
+	metrics.queueWait.write(&b)
+	metrics.queueRejected.write(&b)
+	for _, g := range m.gauges {
+		g.write(&b)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
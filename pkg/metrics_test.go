@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newCounterVec("test_total", "Test.", "a", "b")
+	c.Inc("x", "y\"")
+	c.Add(2, "x", "y\"")
+
+	h := newHistogramVec("test_seconds", "Test.", []float64{0.1, 1})
+	h.Observe(0.5)
+	h.Observe(2)
+
+	var b bytes.Buffer
+	c.write(&b)
+	h.write(&b)
+
+	assert.Equal(`# HELP test_total Test.
+# TYPE test_total counter
+test_total{a="x",b="y\""} 3
+# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 0
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="+Inf"} 2
+test_seconds_sum 2.5
+test_seconds_count 2
+`, b.String())
+
+	prom := fakePrometheus(t, upSeries(1, 0))
+	defer prom.Close()
+	png := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	png.SetDatasource("replica", NewPrometheus(prom.URL, "/api/v1/query_range"))
+	png.SetDatasource("down", NewPrometheus("http://127.0.0.1:1", "/api/v1/query_range"))
+	png.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?g0.expr=up&g0.ds=replica&format=json", nil))
+	png.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?g0.expr=up&g0.ds=down&format=json", nil))
+
+	m := NewMetrics()
+	m.AddCache(NewCache(100, 0, ""))
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(w.Body.String(), "prometheus_png_cache_items 0\n")
+	assert.Contains(w.Body.String(), `prometheus_png_upstream_duration_seconds_count{datasource="replica",status="ok"} 1`)
+	assert.Contains(w.Body.String(), `prometheus_png_upstream_duration_seconds_count{datasource="down",status="error"} 1`)
+}
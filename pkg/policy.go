@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PolicyOptions restricts queries. Empty option doesn't restrict anything
+type PolicyOptions struct {
+	// regular expressions of allowed and denied metric names. Full match
+	MetricAllow []string
+	MetricDeny  []string
+	// max duration of range vector and subquery
+	MaxRange time.Duration
+	// forbidden functions and aggregations
+	DenyFunctions []string
+	// every selector should have label="value" matcher with value matched by regular expression
+	RequiredMatchers map[string]string
+	// datasources with non-PromQL queries (graphite, static) allowed by policy with rules. Rules are not applied to them
+	DatasourceAllow []string
+}
+
+// Policy checks prometheus expressions before they are sent upstream
+type Policy struct {
+	metricAllow      []*regexp.Regexp
+	metricDeny       []*regexp.Regexp
+	maxRange         time.Duration
+	denyFunctions    map[string]bool
+	requiredMatchers map[string]*regexp.Regexp
+	datasourceAllow  map[string]bool
+}
+
+func compileFullMatch(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func NewPolicy(options PolicyOptions) (*Policy, error) {
+	p := &Policy{
+		maxRange:         options.MaxRange,
+		denyFunctions:    make(map[string]bool),
+		requiredMatchers: make(map[string]*regexp.Regexp),
+		datasourceAllow:  make(map[string]bool),
+	}
+	for _, expr := range options.MetricAllow {
+		re, err := compileFullMatch(expr)
+		if err != nil {
+			return nil, err
+		}
+		p.metricAllow = append(p.metricAllow, re)
+	}
+	for _, expr := range options.MetricDeny {
+		re, err := compileFullMatch(expr)
+		if err != nil {
+			return nil, err
+		}
+		p.metricDeny = append(p.metricDeny, re)
+	}
+	for _, f := range options.DenyFunctions {
+		p.denyFunctions[strings.ToLower(f)] = true
+	}
+	for label, expr := range options.RequiredMatchers {
+		re, err := compileFullMatch(expr)
+		if err != nil {
+			return nil, err
+		}
+		p.requiredMatchers[label] = re
+	}
+	for _, ds := range options.DatasourceAllow {
+		p.datasourceAllow[ds] = true
+	}
+	return p, nil
+}
+
+func (p *Policy) hasRules() bool {
+	return len(p.metricAllow) > 0 || len(p.metricDeny) > 0 || p.maxRange > 0 ||
+		len(p.denyFunctions) > 0 || len(p.requiredMatchers) > 0
+}
+
+// CheckDatasource returns error if policy has rules and non-PromQL datasource is not allowed explicitly
+func (p *Policy) CheckDatasource(name string) error {
+	if !p.hasRules() || p.datasourceAllow[name] {
+		return nil
+	}
+	return fmt.Errorf("datasource %#v is not allowed by policy", name)
+}
+
+func matchAny(list []*regexp.Regexp, s string) bool {
+	for _, re := range list {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) checkMetric(sel promSelector) error {
+	name := sel.name
+	for _, m := range sel.matchers {
+		if m.name == "__name__" {
+			if m.op != "=" {
+				if len(p.metricAllow) > 0 || len(p.metricDeny) > 0 {
+					return fmt.Errorf("metric name should be matched with =")
+				}
+				return nil
+			}
+			name = m.value
+		}
+	}
+	if name == "" {
+		if len(p.metricAllow) > 0 || len(p.metricDeny) > 0 {
+			return fmt.Errorf("metric name is required")
+		}
+		return nil
+	}
+	if len(p.metricAllow) > 0 && !matchAny(p.metricAllow, name) {
+		return fmt.Errorf("metric %#v is not allowed", name)
+	}
+	if matchAny(p.metricDeny, name) {
+		return fmt.Errorf("metric %#v is denied", name)
+	}
+	return nil
+}
+
+func (p *Policy) checkMatchers(sel promSelector) error {
+RequiredLoop:
+	for label, re := range p.requiredMatchers {
+		for _, m := range sel.matchers {
+			if m.name == label && m.op == "=" && re.MatchString(m.value) {
+				continue RequiredLoop
+			}
+		}
+		return fmt.Errorf("selector should have %s=\"...\" matcher with value matching %s", label, re.String())
+	}
+	return nil
+}
+
+// Check returns error if expression violates policy
+func (p *Policy) Check(expr string) error {
+	q, err := parsePromQuery(expr)
+	if err != nil {
+		return fmt.Errorf("cannot parse expression: %s", err)
+	}
+	for _, f := range q.functions {
+		if p.denyFunctions[strings.ToLower(f)] {
+			return fmt.Errorf("function %s is not allowed", f)
+		}
+	}
+	if p.maxRange > 0 {
+		for _, d := range q.ranges {
+			if d > p.maxRange {
+				return fmt.Errorf("range %s is greater than allowed %s", d, p.maxRange)
+			}
+		}
+	}
+	for _, sel := range q.selectors {
+		if err := p.checkMetric(sel); err != nil {
+			return err
+		}
+		if err := p.checkMatchers(sel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePromQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	q, err := parsePromQuery(`sum by (job, instance) (rate(http_requests_total{code=~"5..", handler!='/'}[5m] offset 1h)) / on(job) group_left sum(up{__name__="up"}) > bool 0`)
+	assert.NoError(err)
+	assert.Equal([]string{"sum", "rate", "sum"}, q.functions)
+	assert.Equal([]time.Duration{5 * time.Minute}, q.ranges)
+	if assert.Len(q.selectors, 2) {
+		assert.Equal("http_requests_total", q.selectors[0].name)
+		assert.Equal([]promMatcher{{"code", "=~", "5.."}, {"handler", "!=", "/"}}, q.selectors[0].matchers)
+		assert.Equal("up", q.selectors[1].name)
+	}
+
+	q, err = parsePromQuery(`max_over_time(rate(x{namespace="a"}[1m])[1d2h:5m])`)
+	assert.NoError(err)
+	assert.Equal([]time.Duration{time.Minute, 26 * time.Hour}, q.ranges)
+	assert.Equal([]promSelector{{"x", []promMatcher{{"namespace", "=", "a"}}}}, q.selectors)
+
+	q, err = parsePromQuery(`max_over_time(x[30m:])`)
+	assert.NoError(err)
+	assert.Equal([]promSelector{{"x", nil}}, q.selectors)
+
+	// recording rule names may contain ":"
+	q, err = parsePromQuery(`job:up:sum[5m]`)
+	assert.NoError(err)
+	assert.Equal([]promSelector{{"job:up:sum", nil}}, q.selectors)
+
+	_, err = parsePromQuery(`max_over_time(x[30m:5x])`)
+	assert.Error(err)
+
+	_, err = parsePromQuery(`x{a="b"`)
+	assert.Error(err)
+	_, err = parsePromQuery(`rate(x[5m)`)
+	assert.Error(err)
+}
+
+func TestPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := NewPolicy(PolicyOptions{
+		MetricAllow:      []string{"node_.*", "up"},
+		MetricDeny:       []string{"node_secret"},
+		MaxRange:         time.Hour,
+		DenyFunctions:    []string{"count_values"},
+		RequiredMatchers: map[string]string{"namespace": "team-a|team-b"},
+	})
+	assert.NoError(err)
+
+	assert.NoError(p.Check(`rate(node_cpu_seconds_total{namespace="team-a"}[5m])`))
+	assert.NoError(p.Check(`{__name__="up", namespace="team-b"}`))
+	assert.Error(p.Check(`up{namespace="team-c"}`))
+	assert.Error(p.Check(`up{namespace=~"team-a"}`))
+	assert.Error(p.Check(`up`))
+	assert.Error(p.Check(`http_requests_total{namespace="team-a"}`))
+	assert.Error(p.Check(`node_secret{namespace="team-a"}`))
+	assert.Error(p.Check(`{__name__=~"node_.*", namespace="team-a"}`))
+	assert.Error(p.Check(`rate(up{namespace="team-a"}[2h])`))
+	assert.Error(p.Check(`count_values("v", up{namespace="team-a"})`))
+	assert.NoError(p.Check(`max_over_time(rate(up{namespace="team-a"}[5m])[1h:5m])`))
+	assert.NoError(p.Check(`max_over_time(up{namespace="team-a"}[30m:])`))
+
+	assert.Error(p.CheckDatasource("graphite"))
+
+	p, err = NewPolicy(PolicyOptions{MetricAllow: []string{"up"}, DatasourceAllow: []string{"static"}})
+	assert.NoError(err)
+	assert.NoError(p.CheckDatasource("static"))
+	assert.Error(p.CheckDatasource("graphite"))
+
+	p, err = NewPolicy(PolicyOptions{})
+	assert.NoError(err)
+	assert.NoError(p.CheckDatasource("graphite"))
+}
+
+func TestPolicyRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t)
+	defer prom.Close()
+
+	p, _ := NewPolicy(PolicyOptions{MetricDeny: []string{"secret"}})
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetPolicy(p)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g1.expr=secret&format=json", nil))
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Len(prom.queries(), 0)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&format=json", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Len(prom.queries(), 1)
+}
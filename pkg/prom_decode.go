@@ -0,0 +1,426 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Streaming decoder of query_range responses. Samples are written directly
+// into float64 slices aligned to start and step of the query, gaps are NaN
+
+type matrixSeries struct {
+	metric map[string]string
+	// timestamp of values[0]
+	start  int64
+	step   int64
+	values []float64
+}
+
+type jsonReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (j *jsonReader) next() (byte, error) {
+	for {
+		c, err := j.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c, nil
+	}
+}
+
+func (j *jsonReader) expect(want byte) error {
+	c, err := j.next()
+	if err != nil {
+		return err
+	}
+	if c != want {
+		return fmt.Errorf("invalid json: expected %q, got %q", want, c)
+	}
+	return nil
+}
+
+var errInvalidString = errors.New("invalid json string")
+
+// jsonEscapes maps single character escapes of json string
+var jsonEscapes = map[byte]byte{
+	'"':  '"',
+	'\\': '\\',
+	'/':  '/',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+}
+
+// readHex4 reads 4 hex digits of \uXXXX escape
+func (j *jsonReader) readHex4() (rune, error) {
+	var r rune
+	for i := 0; i < 4; i++ {
+		c, err := j.r.ReadByte()
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, errInvalidString
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, nil
+}
+
+// readStringBytes reads string after opening quote and decodes json escapes. Result is valid until next read
+func (j *jsonReader) readStringBytes() ([]byte, error) {
+	j.buf = j.buf[:0]
+	for {
+		c, err := j.r.ReadByte()
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if c == '"' {
+			return j.buf, nil
+		}
+		if c != '\\' {
+			j.buf = append(j.buf, c)
+			continue
+		}
+
+		c, err = j.r.ReadByte()
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if c != 'u' {
+			e, ok := jsonEscapes[c]
+			if !ok {
+				return nil, errInvalidString
+			}
+			j.buf = append(j.buf, e)
+			continue
+		}
+
+		r, err := j.readHex4()
+		if err != nil {
+			return nil, err
+		}
+		// character outside of BMP is encoded as surrogate pair \uD83D\uDE00
+		for utf16.IsSurrogate(r) {
+			next, _ := j.r.Peek(2)
+			if string(next) != `\u` {
+				r = unicode.ReplacementChar
+				break
+			}
+			j.r.Discard(2)
+			low, err := j.readHex4()
+			if err != nil {
+				return nil, err
+			}
+			if pair := utf16.DecodeRune(r, low); pair != unicode.ReplacementChar {
+				r = pair
+				break
+			}
+			// unpaired surrogate, second escape is decoded separately
+			j.buf = appendRune(j.buf, unicode.ReplacementChar)
+			r = low
+		}
+		j.buf = appendRune(j.buf, r)
+	}
+}
+
+func appendRune(buf []byte, r rune) []byte {
+	var b [utf8.UTFMax]byte
+	return append(buf, b[:utf8.EncodeRune(b[:], r)]...)
+}
+
+func (j *jsonReader) readString() (string, error) {
+	if err := j.expect('"'); err != nil {
+		return "", err
+	}
+	b, err := j.readStringBytes()
+	return string(b), err
+}
+
+// readNumber reads number starting with first byte c. Result is valid until next read
+func (j *jsonReader) readNumber(c byte) ([]byte, error) {
+	j.buf = append(j.buf[:0], c)
+	for {
+		c, err := j.r.ReadByte()
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if (c >= '0' && c <= '9') || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-' {
+			j.buf = append(j.buf, c)
+			continue
+		}
+		j.r.UnreadByte()
+		return j.buf, nil
+	}
+}
+
+// skipValue skips any json value, first byte c is already read
+func (j *jsonReader) skipValue(c byte) error {
+	switch {
+	case c == '"':
+		_, err := j.readStringBytes()
+		return err
+	case c == '{':
+		j.r.UnreadByte()
+		return j.readObject(func(string) error { return j.skip() })
+	case c == '[':
+		j.r.UnreadByte()
+		return j.readArray(j.skip)
+	case c == 't' || c == 'f' || c == 'n':
+		for {
+			c, err := j.r.ReadByte()
+			if err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			if c < 'a' || c > 'z' {
+				j.r.UnreadByte()
+				return nil
+			}
+		}
+	default:
+		_, err := j.readNumber(c)
+		return err
+	}
+}
+
+// readObject calls fn for every key of object
+func (j *jsonReader) readObject(fn func(key string) error) error {
+	if err := j.expect('{'); err != nil {
+		return err
+	}
+	for first := true; ; first = false {
+		c, err := j.next()
+		if err != nil {
+			return err
+		}
+		if c == '}' && first {
+			return nil
+		}
+		if c != '"' {
+			return fmt.Errorf("invalid json: expected object key, got %q", c)
+		}
+		key, err := j.readStringBytes()
+		if err != nil {
+			return err
+		}
+		if err := j.expect(':'); err != nil {
+			return err
+		}
+		if err := fn(string(key)); err != nil {
+			return err
+		}
+		if c, err = j.next(); err != nil {
+			return err
+		}
+		if c == '}' {
+			return nil
+		}
+		if c != ',' {
+			return fmt.Errorf("invalid json: expected ',' or '}', got %q", c)
+		}
+	}
+}
+
+// readArray calls fn for every element of array, fn should read element
+func (j *jsonReader) readArray(fn func() error) error {
+	if err := j.expect('['); err != nil {
+		return err
+	}
+	c, err := j.next()
+	if err != nil {
+		return err
+	}
+	if c == ']' {
+		return nil
+	}
+	j.r.UnreadByte()
+	for {
+		if err := fn(); err != nil {
+			return err
+		}
+		if c, err = j.next(); err != nil {
+			return err
+		}
+		if c == ']' {
+			return nil
+		}
+		if c != ',' {
+			return fmt.Errorf("invalid json: expected ',' or ']', got %q", c)
+		}
+	}
+}
+
+func (j *jsonReader) skip() error {
+	c, err := j.next()
+	if err != nil {
+		return err
+	}
+	return j.skipValue(c)
+}
+
+// readSample reads [timestamp, "value"]
+func (j *jsonReader) readSample() (float64, float64, error) {
+	if err := j.expect('['); err != nil {
+		return 0, 0, err
+	}
+	c, err := j.next()
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := j.readNumber(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	ts, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := j.expect(','); err != nil {
+		return 0, 0, err
+	}
+	if err := j.expect('"'); err != nil {
+		return 0, 0, err
+	}
+	if b, err = j.readStringBytes(); err != nil {
+		return 0, 0, err
+	}
+	v, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ts, v, j.expect(']')
+}
+
+func (j *jsonReader) readSeries(start, step int64, points int) (*matrixSeries, error) {
+	s := &matrixSeries{}
+	first, last := points, -1
+	var values []float64
+
+	err := j.readObject(func(key string) error {
+		switch key {
+		case "metric":
+			s.metric = make(map[string]string)
+			return j.readObject(func(label string) error {
+				value, err := j.readString()
+				s.metric[label] = value
+				return err
+			})
+		case "values":
+			values = make([]float64, points)
+			for i := range values {
+				values[i] = math.NaN()
+			}
+			return j.readArray(func() error {
+				ts, v, err := j.readSample()
+				if err != nil {
+					return err
+				}
+				i := int(math.Floor((ts-float64(start))/float64(step) + 0.5))
+				// points outside of requested range are ignored
+				if i < 0 || i >= points {
+					return nil
+				}
+				values[i] = v
+				if i < first {
+					first = i
+				}
+				if i > last {
+					last = i
+				}
+				return nil
+			})
+		}
+		return j.skip()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if last < first {
+		// series without points
+		return s, nil
+	}
+	s.start = start + int64(first)*step
+	s.step = step
+	s.values = values[first : last+1]
+	return s, nil
+}
+
+// decodeMatrix reads query_range response evaluated at start, start+step, ..., end
+func decodeMatrix(r io.Reader, start, end, step int64) ([]*matrixSeries, error) {
+	j := &jsonReader{r: bufio.NewReaderSize(r, 64*1024)}
+	points := int((end-start)/step) + 1
+
+	var result []*matrixSeries
+	var status, errorMsg, resultType string
+
+	err := j.readObject(func(key string) error {
+		var err error
+		switch key {
+		case "status":
+			status, err = j.readString()
+		case "error":
+			errorMsg, err = j.readString()
+		case "data":
+			err = j.readObject(func(key string) error {
+				switch key {
+				case "resultType":
+					var err error
+					resultType, err = j.readString()
+					return err
+				case "result":
+					return j.readArray(func() error {
+						s, err := j.readSeries(start, step, points)
+						if err == nil && s.values != nil {
+							result = append(result, s)
+						}
+						return err
+					})
+				}
+				return j.skip()
+			})
+		default:
+			err = j.skip()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if status != "success" {
+		if errorMsg == "" {
+			errorMsg = fmt.Sprintf("prometheus status %#v", status)
+		}
+		return nil, errors.New(errorMsg)
+	}
+	if resultType != "" && resultType != "matrix" {
+		return nil, fmt.Errorf("unexpected result type %#v", resultType)
+	}
+	return result, nil
+}
@@ -1,8 +1,11 @@
 package pkg
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -24,3 +27,111 @@ func TestUnmarshal(t *testing.T) {
 	assert.Equal(int64(1537555404), res.Data.Result[0].Values[360].Timestamp)
 	assert.Equal(66039.0, res.Data.Result[0].Values[360].Value)
 }
+
+func TestDecodeMatrix(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := ioutil.ReadFile("test1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := decodeMatrix(bytes.NewReader(data), 1537551804, 1537555404, 10)
+	assert.NoError(err)
+	if assert.Len(result, 3) {
+		assert.Equal("diskio_writes", result[0].metric["__name__"])
+		assert.Equal(int64(1537551804), result[0].start)
+		assert.Len(result[0].values, 361)
+		assert.Equal(66039.0, result[0].values[360])
+	}
+
+	// gaps, values outside of range, special values, unknown keys
+	result, err = decodeMatrix(strings.NewReader(`{"status":"success","warnings":["w"],"data":{"resultType":"matrix","result":[`+
+		`{"metric":{"a":"\u0062\""},"values":[[90,"0"],[100,"1"],[120,"NaN"],[130,"+Inf"],[200,"2"]],"extra":{"x":[1,true,null]}},`+
+		`{"metric":{},"values":[]}]}}`), 100, 150, 10)
+	assert.NoError(err)
+	if assert.Len(result, 1) {
+		s := result[0]
+		assert.Equal(`b"`, s.metric["a"])
+		assert.Equal(int64(100), s.start)
+		assert.Len(s.values, 4)
+		assert.Equal(1.0, s.values[0])
+		assert.True(math.IsNaN(s.values[1]))
+		assert.True(math.IsNaN(s.values[2]))
+		assert.True(math.IsInf(s.values[3], 1))
+	}
+
+	_, err = decodeMatrix(strings.NewReader(`{"status":"error","errorType":"bad_data","error":"parse error"}`), 0, 10, 1)
+	assert.EqualError(err, "parse error")
+
+	_, err = decodeMatrix(strings.NewReader(`{"status":"success","data":{"resultType":"matrix","result":[{"values":[[1,"1"]`), 0, 10, 1)
+	assert.Error(err)
+}
+
+func TestDecodeMatrixEscapes(t *testing.T) {
+	assert := assert.New(t)
+
+	decode := func(value string) (string, error) {
+		result, err := decodeMatrix(strings.NewReader(`{"status":"success","data":{"resultType":"matrix","result":[`+
+			`{"metric":{"path":"`+value+`"},"values":[[100,"1"]]}]}}`), 100, 100, 10)
+		if err != nil {
+			return "", err
+		}
+		return result[0].metric["path"], nil
+	}
+
+	// result should be the same as of encoding/json
+	for _, value := range []string{
+		`\/var\/log`,
+		`tab\tnew\nline\r\b\f\\\"`,
+		`\u00e9\u4e16`,
+		`\ud83d\ude00`,
+		`\uD83D\uDE00`,
+		`\ud83d`,
+		`\ud83dx`,
+		`\ud83d\u0041`,
+		`\ude00\ud83d\ude00`,
+	} {
+		var expected string
+		assert.NoError(json.Unmarshal([]byte(`"`+value+`"`), &expected))
+		s, err := decode(value)
+		assert.NoError(err, value)
+		assert.Equal(expected, s, value)
+	}
+
+	for _, value := range []string{`\x41`, `\u12`, `\u12g4`, `\'`} {
+		_, err := decode(value)
+		assert.Error(err, value)
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data, err := ioutil.ReadFile("test1.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res := &PrometheusResponse{}
+		if err := json.Unmarshal(data, res); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeMatrix(b *testing.B) {
+	data, err := ioutil.ReadFile("test1.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeMatrix(bytes.NewReader(data), 1537551804, 1537555404, 10); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Prometheus queries prometheus HTTP API query_range endpoint
+type Prometheus struct {
+	addr           string
+	queryRangePath string
+}
+
+func NewPrometheus(addr string, queryRangePath string) *Prometheus {
+	return &Prometheus{addr: addr, queryRangePath: queryRangePath}
+}
+
+// Ping checks prometheus readiness endpoint
+func (p *Prometheus) Ping(ctx context.Context) error {
+	u, err := url.Parse(p.addr)
+	if err != nil {
+		return err
+	}
+	u.Path = "/-/ready"
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("prometheus status: %s", res.Status)
+	}
+	return nil
+}
+
+func (p *Prometheus) QueryRange(ctx context.Context, expr string, start, end, step int64) ([]*Series, error) {
+	u, err := url.Parse(p.addr)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = p.queryRangePath
+
+	q := u.Query()
+	q.Set("query", expr)
+	q.Set("start", strconv.FormatInt(start, 10))
+	q.Set("end", strconv.FormatInt(end, 10))
+	q.Set("step", strconv.FormatInt(step, 10))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// with explicit Accept-Encoding transport doesn't decompress response
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("prometheus status: %s", res.Status)
+	}
+
+	body, err := decodedBody(res.Header.Get("Content-Encoding"), res.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	result, err := decodeMatrix(body, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+
+	return matrixToSeries(result), nil
+}
@@ -0,0 +1,275 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Minimal PromQL analyzer. It doesn't build full AST, only collects
+// vector selectors, called functions and range durations for policy checks
+
+type promTokenType int
+
+const (
+	promTokenIdent promTokenType = iota
+	promTokenString
+	promTokenNumber
+	promTokenPunct
+)
+
+type promToken struct {
+	typ   promTokenType
+	value string
+}
+
+func promLex(expr string) ([]promToken, error) {
+	var tokens []promToken
+	r := []rune(expr)
+	// ":" in brackets separates subquery range and step, outside it is part of metric name
+	brackets := 0
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '#':
+			// comment till end of line
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for ; j < len(r) && r[j] != c; j++ {
+				if r[j] == '\\' && c != '`' {
+					j++
+				}
+			}
+			if j >= len(r) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			s := string(r[i : j+1])
+			if c == '`' {
+				s = s[1 : len(s)-1]
+			} else {
+				if c == '\'' {
+					s = `"` + strings.Replace(s[1:len(s)-1], `"`, `\"`, -1) + `"`
+				}
+				unquoted, err := strconv.Unquote(s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid string at position %d: %s", i, err)
+				}
+				s = unquoted
+			}
+			tokens = append(tokens, promToken{promTokenString, s})
+			i = j + 1
+		case unicode.IsLetter(c) || c == '_' || (c == ':' && brackets == 0):
+			j := i
+			for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '_' || r[j] == ':') {
+				j++
+			}
+			tokens = append(tokens, promToken{promTokenIdent, string(r[i:j])})
+			i = j
+		case unicode.IsDigit(c) || c == '.':
+			// numbers and durations: 1, 1.5, 1e3, 0x1f, 5m, 1h30m
+			j := i
+			for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '.' ||
+				((r[j] == '+' || r[j] == '-') && j > i && (r[j-1] == 'e' || r[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, promToken{promTokenNumber, string(r[i:j])})
+			i = j
+		default:
+			if i+1 < len(r) {
+				switch string(r[i : i+2]) {
+				case "==", "!=", ">=", "<=", "=~", "!~":
+					tokens = append(tokens, promToken{promTokenPunct, string(r[i : i+2])})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("{}()[],=<>+-*/%^@:", c) {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+			switch c {
+			case '[':
+				brackets++
+			case ']':
+				brackets--
+			}
+			tokens = append(tokens, promToken{promTokenPunct, string(c)})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// parsePromDuration parses prometheus duration like 1h30m, 5m, 1d, 1w, 1y
+func parsePromDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  24 * time.Hour,
+		"w":  7 * 24 * time.Hour,
+		"y":  365 * 24 * time.Hour,
+	}
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	var d time.Duration
+	rest := s
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		j := i
+		for j < len(rest) && (rest[j] < '0' || rest[j] > '9') {
+			j++
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		unit, ok := units[rest[i:j]]
+		if err != nil || !ok {
+			return 0, fmt.Errorf("invalid duration %#v", s)
+		}
+		d += time.Duration(n) * unit
+		rest = rest[j:]
+	}
+	return d, nil
+}
+
+type promMatcher struct {
+	name  string
+	op    string
+	value string
+}
+
+type promSelector struct {
+	name     string
+	matchers []promMatcher
+}
+
+type promQuery struct {
+	selectors []promSelector
+	functions []string
+	ranges    []time.Duration
+}
+
+var promKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true, "group_left": true, "group_right": true,
+	"offset": true, "bool": true, "and": true, "or": true, "unless": true, "atan2": true,
+	"inf": true, "nan": true,
+}
+
+// keywords followed by label list in parentheses
+var promLabelListKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true, "group_left": true, "group_right": true,
+}
+
+// aggregation operators, may be followed by by/without clause: sum by (job) (x)
+var promAggregators = map[string]bool{
+	"sum": true, "min": true, "max": true, "avg": true, "group": true, "stddev": true, "stdvar": true,
+	"count": true, "count_values": true, "bottomk": true, "topk": true, "quantile": true,
+	"limitk": true, "limit_ratio": true,
+}
+
+func parsePromMatchers(tokens []promToken, i int) ([]promMatcher, int, error) {
+	// tokens[i] is "{"
+	var matchers []promMatcher
+	i++
+	for i < len(tokens) && tokens[i].value != "}" {
+		if i+2 >= len(tokens) || tokens[i].typ == promTokenString || tokens[i+2].typ != promTokenString {
+			return nil, 0, fmt.Errorf("invalid label matcher")
+		}
+		switch tokens[i+1].value {
+		case "=", "!=", "=~", "!~":
+		default:
+			return nil, 0, fmt.Errorf("invalid label matcher operator %#v", tokens[i+1].value)
+		}
+		matchers = append(matchers, promMatcher{tokens[i].value, tokens[i+1].value, tokens[i+2].value})
+		i += 3
+		if i < len(tokens) && tokens[i].value == "," {
+			i++
+		}
+	}
+	if i >= len(tokens) {
+		return nil, 0, fmt.Errorf("unclosed label matchers")
+	}
+	return matchers, i + 1, nil
+}
+
+func parsePromQuery(expr string) (*promQuery, error) {
+	tokens, err := promLex(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &promQuery{}
+	depth := 0
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		switch {
+		case t.typ == promTokenIdent && promLabelListKeywords[strings.ToLower(t.value)]:
+			i++
+			if i < len(tokens) && tokens[i].value == "(" {
+				for i < len(tokens) && tokens[i].value != ")" {
+					i++
+				}
+				i++
+			}
+		case t.typ == promTokenIdent && promKeywords[strings.ToLower(t.value)]:
+			i++
+		case t.typ == promTokenIdent && i+1 < len(tokens) && (tokens[i+1].value == "(" ||
+			promAggregators[strings.ToLower(t.value)] && promLabelListKeywords[strings.ToLower(tokens[i+1].value)]):
+			q.functions = append(q.functions, t.value)
+			i++
+		case t.typ == promTokenIdent || t.value == "{":
+			sel := promSelector{}
+			if t.typ == promTokenIdent {
+				sel.name = t.value
+				i++
+			}
+			if i < len(tokens) && tokens[i].value == "{" {
+				sel.matchers, i, err = parsePromMatchers(tokens, i)
+				if err != nil {
+					return nil, err
+				}
+			}
+			q.selectors = append(q.selectors, sel)
+		case t.value == "[":
+			depth++
+			i++
+			if i < len(tokens) && tokens[i].typ == promTokenNumber {
+				d, err := parsePromDuration(tokens[i].value)
+				if err != nil {
+					return nil, err
+				}
+				q.ranges = append(q.ranges, d)
+				i++
+			}
+			// subquery step: [1h:5m] or [1h:]
+			if i < len(tokens) && tokens[i].value == ":" {
+				i++
+				if i < len(tokens) && tokens[i].typ == promTokenNumber {
+					if _, err := parsePromDuration(tokens[i].value); err != nil {
+						return nil, err
+					}
+					i++
+				}
+			}
+		case t.value == "]":
+			depth--
+			i++
+		default:
+			i++
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets")
+	}
+	return q, nil
+}
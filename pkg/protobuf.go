@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Minimal protobuf wire format writer and reader for prometheus remote read messages
+
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+var errProtobufCorrupt = errors.New("protobuf: corrupt message")
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+type pbWriter struct {
+	b []byte
+}
+
+func (w *pbWriter) key(field int, wireType int) {
+	w.b = appendUvarint(w.b, uint64(field)<<3|uint64(wireType))
+}
+
+func (w *pbWriter) varint(field int, v uint64) {
+	w.key(field, pbVarint)
+	w.b = appendUvarint(w.b, v)
+}
+
+func (w *pbWriter) double(field int, v float64) {
+	w.key(field, pbFixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	w.b = append(w.b, buf[:]...)
+}
+
+func (w *pbWriter) bytes(field int, data []byte) {
+	w.key(field, pbBytes)
+	w.b = appendUvarint(w.b, uint64(len(data)))
+	w.b = append(w.b, data...)
+}
+
+func (w *pbWriter) string(field int, s string) {
+	w.bytes(field, []byte(s))
+}
+
+func (w *pbWriter) message(field int, m *pbWriter) {
+	w.bytes(field, m.b)
+}
+
+// pbField is single decoded field. value is set for varint and fixed types, data for length-delimited
+type pbField struct {
+	num   int
+	value uint64
+	data  []byte
+}
+
+// pbRead calls fn for every field of message
+func pbRead(b []byte, fn func(f pbField) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errProtobufCorrupt
+		}
+		b = b[n:]
+		f := pbField{num: int(key >> 3)}
+		switch key & 7 {
+		case pbVarint:
+			f.value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errProtobufCorrupt
+			}
+			b = b[n:]
+		case pbFixed64:
+			if len(b) < 8 {
+				return errProtobufCorrupt
+			}
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case pbBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errProtobufCorrupt
+			}
+			f.data = b[n : n+int(l)]
+			b = b[n+int(l):]
+		case pbFixed32:
+			if len(b) < 4 {
+				return errProtobufCorrupt
+			}
+			f.value = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		default:
+			return errProtobufCorrupt
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+)
+
+// remote read label matcher types
+var remoteReadMatchType = map[string]uint64{
+	"=":  0,
+	"!=": 1,
+	"=~": 2,
+	"!~": 3,
+}
+
+// RemoteRead reads raw samples with prometheus remote read protocol (/api/v1/read)
+// and consolidates them to query step. Only simple selectors like metric{label="value"} are supported
+type RemoteRead struct {
+	url             string
+	maxResponseSize int
+}
+
+// defaultMaxResponseSize limits decoded remote read response
+const defaultMaxResponseSize = 32 << 20
+
+// NewRemoteRead creates datasource with limit of decoded response size, defaultMaxResponseSize if 0
+func NewRemoteRead(url string, maxResponseSize int) *RemoteRead {
+	if maxResponseSize <= 0 {
+		maxResponseSize = defaultMaxResponseSize
+	}
+	return &RemoteRead{url: url, maxResponseSize: maxResponseSize}
+}
+
+// selectorMatchers returns label matchers of expression consisting of single vector selector
+func selectorMatchers(expr string) ([]promMatcher, error) {
+	q, err := parsePromQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(q.selectors) != 1 || len(q.functions) > 0 || len(q.ranges) > 0 {
+		return nil, fmt.Errorf("remote read supports only simple selectors, got %#v", expr)
+	}
+	sel := q.selectors[0]
+	matchers := sel.matchers
+	if sel.name != "" {
+		matchers = append([]promMatcher{{name: "__name__", op: "=", value: sel.name}}, matchers...)
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("selector without matchers")
+	}
+	return matchers, nil
+}
+
+func marshalReadRequest(matchers []promMatcher, startMs, endMs int64) []byte {
+	query := &pbWriter{}
+	query.varint(1, uint64(startMs))
+	query.varint(2, uint64(endMs))
+	for _, m := range matchers {
+		matcher := &pbWriter{}
+		matcher.varint(1, remoteReadMatchType[m.op])
+		matcher.string(2, m.name)
+		matcher.string(3, m.value)
+		query.message(3, matcher)
+	}
+
+	req := &pbWriter{}
+	req.message(1, query)
+	return req.b
+}
+
+func unmarshalReadResponse(data []byte, start, end, step int64) ([]*Series, error) {
+	var result []*Series
+
+	err := pbRead(data, func(f pbField) error {
+		if f.num != 1 {
+			return nil
+		}
+		// QueryResult
+		return pbRead(f.data, func(f pbField) error {
+			if f.num != 1 {
+				return nil
+			}
+			// TimeSeries
+			metric := make(map[string]string)
+			c := newConsolidatedSeries(start, end, step)
+			err := pbRead(f.data, func(f pbField) error {
+				switch f.num {
+				case 1:
+					var name, value string
+					err := pbRead(f.data, func(f pbField) error {
+						switch f.num {
+						case 1:
+							name = string(f.data)
+						case 2:
+							value = string(f.data)
+						}
+						return nil
+					})
+					metric[name] = value
+					return err
+				case 2:
+					var value float64
+					var ts int64
+					err := pbRead(f.data, func(f pbField) error {
+						switch f.num {
+						case 1:
+							value = math.Float64frombits(f.value)
+						case 2:
+							ts = int64(f.value)
+						}
+						return nil
+					})
+					c.add(float64(ts)/1000, value)
+					return err
+				}
+				return nil
+			})
+			if s := c.series(metric); s != nil {
+				result = append(result, s)
+			}
+			return err
+		})
+	})
+	return result, err
+}
+
+// Ping sends read request without queries
+func (rr *RemoteRead) Ping(ctx context.Context) error {
+	req, err := http.NewRequest("POST", rr.url, bytes.NewReader(snappyEncode(nil)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, _ := ioutil.ReadAll(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote read status: %s: %s", res.Status, bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+// QueryRange reads samples in (start - step, end] and returns series aligned to start and step
+func (rr *RemoteRead) QueryRange(ctx context.Context, expr string, start, end, step int64) ([]*Series, error) {
+	matchers, err := selectorMatchers(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	body := snappyEncode(marshalReadRequest(matchers, (start-step)*1000, end*1000))
+	req, err := http.NewRequest("POST", rr.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// compressed block is never much larger than decoded data
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, int64(rr.maxResponseSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote read status: %s: %s", res.Status, bytes.TrimSpace(data))
+	}
+	if len(data) > rr.maxResponseSize {
+		return nil, fmt.Errorf("remote read response exceeds limit of %d bytes", rr.maxResponseSize)
+	}
+
+	if data, err = snappyDecode(data, rr.maxResponseSize); err != nil {
+		return nil, err
+	}
+	return unmarshalReadResponse(data, start, end, step)
+}
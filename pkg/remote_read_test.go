@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnappy(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, n := range []int{0, 1, 60, 61, 256, 257, 65536, 70000} {
+		src := []byte(strings.Repeat("abcdefgh", n/8+1)[:n])
+		dst, err := snappyDecode(snappyEncode(src), defaultMaxResponseSize)
+		assert.NoError(err)
+		assert.Equal(src, dst, "len %d", n)
+	}
+
+	// literal "abcd" and overlapping copy of length 8 with offset 4
+	dst, err := snappyDecode([]byte{12, 3 << 2, 'a', 'b', 'c', 'd', 1 | 4<<2, 4}, defaultMaxResponseSize)
+	assert.NoError(err)
+	assert.Equal("abcdabcdabcd", string(dst))
+
+	_, err = snappyDecode([]byte{12, 3 << 2, 'a', 'b', 'c', 'd', 1 | 4<<2, 5}, defaultMaxResponseSize)
+	assert.Equal(errSnappyCorrupt, err)
+
+	// declared length over limit is rejected before allocation
+	_, err = snappyDecode([]byte{0x80, 0x80, 0x80, 0x80, 0x04, 0}, defaultMaxResponseSize)
+	assert.EqualError(err, "snappy: decoded length 1073741824 exceeds limit 33554432")
+	_, err = snappyDecode(snappyEncode(make([]byte, 100)), 99)
+	assert.Error(err)
+
+	// output can't grow over declared length
+	_, err = snappyDecode([]byte{4, 3 << 2, 'a', 'b', 'c', 'd', 1 | 4<<2, 4}, defaultMaxResponseSize)
+	assert.Equal(errSnappyCorrupt, err)
+}
+
+type remoteReadSample struct {
+	ts    int64
+	value float64
+}
+
+func remoteReadServer(t *testing.T, labels map[string]string, samples []remoteReadSample) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		body, _ := ioutil.ReadAll(r.Body)
+		data, err := snappyDecode(body, defaultMaxResponseSize)
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// echo request matchers as series labels
+		series := &pbWriter{}
+		err = pbRead(data, func(f pbField) error {
+			return pbRead(f.data, func(f pbField) error {
+				if f.num != 3 {
+					return nil
+				}
+				var name, value string
+				pbRead(f.data, func(f pbField) error {
+					switch f.num {
+					case 2:
+						name = string(f.data)
+					case 3:
+						value = string(f.data)
+					}
+					return nil
+				})
+				label := &pbWriter{}
+				label.string(1, name)
+				label.string(2, value)
+				series.message(1, label)
+				return nil
+			})
+		})
+		assert.NoError(t, err)
+		for name, value := range labels {
+			label := &pbWriter{}
+			label.string(1, name)
+			label.string(2, value)
+			series.message(1, label)
+		}
+		for _, s := range samples {
+			sample := &pbWriter{}
+			sample.double(1, s.value)
+			sample.varint(2, uint64(s.ts))
+			series.message(2, sample)
+		}
+
+		result := &pbWriter{}
+		result.message(1, series)
+		res := &pbWriter{}
+		res.message(1, result)
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Header().Set("Content-Encoding", "snappy")
+		w.Write(snappyEncode(res.b))
+	}))
+}
+
+func TestRemoteRead(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := remoteReadServer(t, map[string]string{"instance": "a"}, []remoteReadSample{
+		{940500, 1},
+		{1000000, 3},
+		{1030000, 4},
+		{1060000, 6},
+		{1200000, 7},
+		{1400000, 100},
+	})
+	defer srv.Close()
+
+	rr := NewRemoteRead(srv.URL, 0)
+	result, err := rr.QueryRange(context.Background(), `up{job="node"}`, 1000, 1300, 60)
+	assert.NoError(err)
+	if assert.Len(result, 1) {
+		s := result[0]
+		assert.Equal(map[string]string{"__name__": "up", "job": "node", "instance": "a"}, s.Labels)
+		assert.Equal(int64(1000), s.StartTime)
+		assert.Equal(int64(60), s.StepTime)
+		if assert.Len(s.Values, 5) {
+			assert.Equal(2.0, s.Values[0])
+			assert.Equal(5.0, s.Values[1])
+			assert.True(math.IsNaN(s.Values[2]))
+			assert.True(math.IsNaN(s.Values[3]))
+			assert.Equal(7.0, s.Values[4])
+		}
+	}
+
+	_, err = rr.QueryRange(context.Background(), `rate(up[5m])`, 1000, 1300, 60)
+	assert.Error(err)
+
+	_, err = NewRemoteRead(srv.URL, 10).QueryRange(context.Background(), `up{job="node"}`, 1000, 1300, 60)
+	assert.Error(err)
+}
+
+func TestRemoteReadDatasource(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := remoteReadServer(t, nil, []remoteReadSample{{1537555344000, 1}})
+	defer srv.Close()
+
+	h := NewPNG("http://127.0.0.1:1", "/api/v1/query_range", time.Second)
+	h.SetDatasource("lts", NewRemoteRead(srv.URL, 0))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g0.ds=lts&from=1537555344&until=1537594944&format=csv", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"up",2018-09-21`)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g0.ds=unknown&format=csv", nil))
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
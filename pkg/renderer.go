@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+)
+
+// Renderer draws metric data to picture. Params are carbonapi render GET-parameters with applied template
+type Renderer interface {
+	Render(params url.Values, results []*types.MetricData, format string) ([]byte, error)
+}
+
+// formatRenderer is implemented by renderers which can't draw all picture formats
+type formatRenderer interface {
+	Formats() []string
+}
+
+// renderFormat reports whether format can be produced with renderer. Data and text formats don't need renderer
+func renderFormat(r Renderer, format string) bool {
+	switch format {
+	case "png", "svg", "jpeg", "pdf":
+	default:
+		return true
+	}
+	fr, ok := r.(formatRenderer)
+	if !ok {
+		return true
+	}
+	for _, f := range fr.Formats() {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// renderers available in current build. "cairo" is registered only with -tags cairo
+var renderers = map[string]Renderer{
+	"go": &goRenderer{},
+}
+
+var defaultRenderer = "go"
+
+// GetRenderer returns renderer by name. Empty name means best available renderer
+func GetRenderer(name string) (Renderer, error) {
+	if name == "" {
+		name = defaultRenderer
+	}
+	r, ok := renderers[name]
+	if !ok {
+		names := make([]string, 0, len(renderers))
+		for k := range renderers {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown renderer %#v, available: %s", name, strings.Join(names, ", "))
+	}
+	return r, nil
+}
+
+func getString(params url.Values, key string, def string) string {
+	if v := params.Get(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getFloat64(params url.Values, key string, def float64) float64 {
+	v, err := strconv.ParseFloat(params.Get(key), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func getBool(params url.Values, key string, def bool) bool {
+	switch params.Get(key) {
+	case "True", "true", "1":
+		return true
+	case "False", "false", "0":
+		return false
+	}
+	return def
+}
+
+func getColorList(params url.Values) []string {
+	s := params.Get("colorList")
+	if s == "" {
+		return defaultColorList
+	}
+	colors := strings.Split(s, ",")
+	for i := range colors {
+		colors[i] = strings.TrimSpace(colors[i])
+	}
+	return colors
+}
+
+func getTimeZone(params url.Values) *time.Location {
+	if tz := params.Get("tz"); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+func getPixelRatio(params url.Values) float64 {
+	pixelRatio := getFloat64(params, "pixelRatio", 1)
+	if pixelRatio <= 0 || math.IsNaN(pixelRatio) {
+		return 1
+	}
+	return pixelRatio
+}
+
+func getQuality(params url.Values) int {
+	quality, err := strconv.Atoi(params.Get("quality"))
+	if err != nil || quality < 1 || quality > 100 {
+		return 85
+	}
+	return quality
+}
@@ -0,0 +1,41 @@
+//go:build cairo
+// +build cairo
+
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-graphite/carbonapi/expr/functions/cairo/png"
+	"github.com/go-graphite/carbonapi/expr/types"
+)
+
+// cairoRenderer draws pictures with carbonapi
+type cairoRenderer struct{}
+
+func init() {
+	renderers["cairo"] = &cairoRenderer{}
+	defaultRenderer = "cairo"
+}
+
+func (cr *cairoRenderer) Formats() []string {
+	return []string{"png", "svg", "jpeg", "pdf"}
+}
+
+func (cr *cairoRenderer) Render(params url.Values, results []*types.MetricData, format string) ([]byte, error) {
+	pictureParams := png.GetPictureParams(&http.Request{Form: params}, results)
+
+	switch format {
+	case "png":
+		return png.MarshalPNG(pictureParams, results), nil
+	case "svg":
+		return png.MarshalSVG(pictureParams, results), nil
+	case "jpeg":
+		return pngToJPEG(png.MarshalPNG(pictureParams, results), getQuality(params))
+	case "pdf":
+		return pngToPDF(png.MarshalPNG(pictureParams, results), pictureParams.PixelRatio)
+	}
+	return nil, fmt.Errorf("format %#v is not supported by cairo renderer", format)
+}
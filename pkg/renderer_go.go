@@ -0,0 +1,487 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+)
+
+// goRenderer is pure Go renderer without cairo dependency.
+// Supports subset of carbonapi parameters: lines, areas, grid, axes, title and legend
+type goRenderer struct{}
+
+type goCanvas struct {
+	img   *image.RGBA
+	scale int
+}
+
+func (gr *goRenderer) Formats() []string {
+	return []string{"png", "jpeg", "pdf"}
+}
+
+func (gr *goRenderer) Render(params url.Values, results []*types.MetricData, format string) ([]byte, error) {
+	switch format {
+	case "png", "jpeg", "pdf":
+	default:
+		return nil, fmt.Errorf("format %#v is not supported by go renderer", format)
+	}
+
+	img := drawGoGraph(params, results)
+
+	var b bytes.Buffer
+	if format == "jpeg" {
+		if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: getQuality(params)}); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	if format == "pdf" {
+		return pngToPDF(b.Bytes(), getPixelRatio(params))
+	}
+	return b.Bytes(), nil
+}
+
+func getRGBA(params url.Values, key string, def string) color.RGBA {
+	if c, ok := parseColor(getString(params, key, def)); ok {
+		return c
+	}
+	c, _ := parseColor(def)
+	return c
+}
+
+func (c *goCanvas) blend(x, y int, clr color.RGBA, alpha float64) {
+	if !(image.Point{x, y}.In(c.img.Rect)) {
+		return
+	}
+	if alpha >= 1 {
+		c.img.SetRGBA(x, y, clr)
+		return
+	}
+	old := c.img.RGBAAt(x, y)
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a)*(1-alpha) + float64(b)*alpha)
+	}
+	c.img.SetRGBA(x, y, color.RGBA{mix(old.R, clr.R), mix(old.G, clr.G), mix(old.B, clr.B), 0xff})
+}
+
+func (c *goCanvas) fillRect(r image.Rectangle, clr color.RGBA, alpha float64) {
+	r = r.Intersect(c.img.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c.blend(x, y, clr, alpha)
+		}
+	}
+}
+
+func (c *goCanvas) line(x0, y0, x1, y1 float64, width float64, clr color.RGBA) {
+	c.dashedLine(x0, y0, x1, y1, width, clr, 0, 0)
+}
+
+// dashedLine draws dashes and gaps of length dash, 0 - solid line. offset is length of path drawn before,
+// returns path length after the line
+func (c *goCanvas) dashedLine(x0, y0, x1, y1 float64, width float64, clr color.RGBA, dash float64, offset float64) float64 {
+	w := int(math.Max(1, math.Round(width)))
+	length := math.Hypot(x1-x0, y1-y0)
+	steps := int(math.Max(math.Abs(x1-x0), math.Abs(y1-y0)))
+	if steps < 1 {
+		steps = 1
+	}
+	for s := 0; s <= steps; s++ {
+		if dash > 0 && math.Mod(offset+length*float64(s)/float64(steps), 2*dash) >= dash {
+			continue
+		}
+		x := int(math.Round(x0+(x1-x0)*float64(s)/float64(steps))) - w/2
+		y := int(math.Round(y0+(y1-y0)*float64(s)/float64(steps))) - w/2
+		c.fillRect(image.Rect(x, y, x+w, y+w), clr, 1)
+	}
+	return offset + length
+}
+
+func (c *goCanvas) textWidth(s string) int {
+	return len(s) * fontGlyphWidth * c.scale
+}
+
+func (c *goCanvas) lineHeight() int {
+	return fontGlyphHeight * c.scale
+}
+
+func (c *goCanvas) text(x, y int, s string, clr color.RGBA) {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch < 0x20 || ch > 0x7e {
+			ch = '?'
+		}
+		glyph := font5x7[ch-0x20]
+		for col := 0; col < 5; col++ {
+			for row := 0; row < 7; row++ {
+				if glyph[col]&(1<<uint(row)) == 0 {
+					continue
+				}
+				px := x + (i*fontGlyphWidth+col)*c.scale
+				py := y + row*c.scale
+				c.fillRect(image.Rect(px, py, px+c.scale, py+c.scale), clr, 1)
+			}
+		}
+	}
+}
+
+// niceStep returns human friendly step (1, 2, 5 * 10^n) to split span on about count parts
+func niceStep(span float64, count int) float64 {
+	if span <= 0 || count < 1 {
+		return 1
+	}
+	raw := span / float64(count)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+var siPrefixes = []struct {
+	value  float64
+	suffix string
+}{
+	{1e15, "P"},
+	{1e12, "T"},
+	{1e9, "G"},
+	{1e6, "M"},
+	{1e3, "K"},
+}
+
+func formatAxisValue(v, step float64) string {
+	unit, suffix := 1.0, ""
+	for _, p := range siPrefixes {
+		if math.Abs(v) >= p.value && step >= p.value/1000 {
+			unit, suffix = p.value, p.suffix
+			break
+		}
+	}
+	decimals := 0
+	if s := step / unit; s < 1 {
+		decimals = int(math.Ceil(-math.Log10(s)))
+	}
+	return strconv.FormatFloat(v/unit, 'f', decimals, 64) + suffix
+}
+
+var timeSteps = []int64{1, 5, 10, 30, 60, 300, 600, 900, 1800, 3600, 2 * 3600, 3 * 3600, 6 * 3600, 12 * 3600, 86400, 2 * 86400, 7 * 86400, 30 * 86400}
+
+func valueAt(r *types.MetricData, t int64) float64 {
+	if r.StepTime <= 0 || t < r.StartTime {
+		return math.NaN()
+	}
+	i := (t - r.StartTime) / r.StepTime
+	if (t-r.StartTime)%r.StepTime != 0 || i >= int64(len(r.Values)) {
+		return math.NaN()
+	}
+	return r.Values[i]
+}
+
+func drawGoGraph(params url.Values, results []*types.MetricData) *image.RGBA {
+	pixelRatio := getPixelRatio(params)
+	width := int(getFloat64(params, "width", 330) * pixelRatio)
+	height := int(getFloat64(params, "height", 250) * pixelRatio)
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	c := &goCanvas{
+		img:   image.NewRGBA(image.Rect(0, 0, width, height)),
+		scale: int(math.Max(1, math.Round(getFloat64(params, "fontSize", 10)*pixelRatio/10))),
+	}
+
+	bgColor := getRGBA(params, "bgcolor", "black")
+	fgColor := getRGBA(params, "fgcolor", "white")
+	gridColor := getRGBA(params, "majorGridLineColor", getString(params, "majorLine", "rose"))
+	colorList := getColorList(params)
+	margin := int(getFloat64(params, "margin", 10) * pixelRatio)
+	lineWidth := getFloat64(params, "lineWidth", 1.2) * pixelRatio
+	areaMode := getString(params, "areaMode", "none")
+	areaAlpha := getFloat64(params, "areaAlpha", math.NaN())
+	if math.IsNaN(areaAlpha) {
+		areaAlpha = 1
+	}
+	graphOnly := getBool(params, "graphOnly", false)
+	hideLegend := getBool(params, "hideLegend", len(results) > 10)
+	hideGrid := getBool(params, "hideGrid", false)
+	hideAxes := getBool(params, "hideAxes", false) || graphOnly
+	title := params.Get("title")
+	tz := getTimeZone(params)
+
+	// series with own color (color, threshold) don't take colors from colorList, same as in carbonapi
+	styles := make([]seriesStyle, len(results))
+	colors := make([]color.RGBA, len(results))
+	colorIndex := 0
+	for n, r := range results {
+		styles[n] = getSeriesStyle(r)
+		name := styles[n].color
+		if name == "" {
+			name = colorList[colorIndex%len(colorList)]
+			colorIndex++
+		}
+		colors[n], _ = parseColor(name)
+	}
+
+	draw.Draw(c.img, c.img.Rect, &image.Uniform{bgColor}, image.ZP, draw.Src)
+
+	area := image.Rect(margin, margin, width-margin, height-margin)
+	lineHeight := c.lineHeight()
+	gap := lineHeight / 2
+
+	if !graphOnly && title != "" {
+		c.text((width-c.textWidth(title))/2, area.Min.Y, title, fgColor)
+		area.Min.Y += lineHeight + gap
+	}
+
+	// vertical bands of drawAsInfinite series are not in legend
+	var legend []int
+	for n := range results {
+		if !styles[n].drawAsInfinite {
+			legend = append(legend, n)
+		}
+	}
+
+	if !graphOnly && !hideLegend && len(legend) > 0 {
+		itemWidth := 0
+		for _, n := range legend {
+			if w := c.textWidth(results[n].Name) + lineHeight + gap; w > itemWidth {
+				itemWidth = w
+			}
+		}
+		columns := (area.Dx() + 2*gap) / (itemWidth + 2*gap)
+		if columns < 1 {
+			columns = 1
+		}
+		rows := (len(legend) + columns - 1) / columns
+		legendTop := area.Max.Y - rows*(lineHeight+gap) + gap
+		for i, n := range legend {
+			x := area.Min.X + (i%columns)*(itemWidth+2*gap)
+			y := legendTop + (i/columns)*(lineHeight+gap)
+			c.fillRect(image.Rect(x, y, x+lineHeight-c.scale, y+lineHeight-c.scale), colors[n], 1)
+			c.text(x+lineHeight+gap/2, y, results[n].Name, fgColor)
+		}
+		area.Max.Y = legendTop - gap
+	}
+
+	stacked := areaMode == "stacked"
+
+	// values to draw, stacked on previous series if needed. drawAsInfinite series are not stacked
+	drawValues := make([]*types.MetricData, len(results))
+	stackedOn := make([]int, len(results))
+	lastStacked := -1
+	for n, r := range results {
+		md := *r
+		md.Values = make([]float64, len(r.Values))
+		copy(md.Values, r.Values)
+		stackedOn[n] = -1
+		if stacked && !styles[n].drawAsInfinite {
+			if lastStacked >= 0 {
+				for i := range md.Values {
+					prev := valueAt(drawValues[lastStacked], md.StartTime+int64(i)*md.StepTime)
+					if !math.IsNaN(prev) && !math.IsNaN(md.Values[i]) {
+						md.Values[i] += prev
+					}
+				}
+			}
+			stackedOn[n] = lastStacked
+			lastStacked = n
+		}
+		drawValues[n] = &md
+	}
+
+	minX, maxX := int64(math.MaxInt64), int64(math.MinInt64)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	hasY := false
+	for n, r := range drawValues {
+		for i, v := range r.Values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			t := r.StartTime + int64(i)*r.StepTime
+			if t < minX {
+				minX = t
+			}
+			if t > maxX {
+				maxX = t
+			}
+			if !styles[n].drawAsInfinite {
+				minY = math.Min(minY, v)
+				maxY = math.Max(maxY, v)
+				hasY = true
+			}
+		}
+	}
+	if !hasY {
+		minY, maxY = 0, 1
+	}
+
+	if minX > maxX {
+		msg := "No Data"
+		c.text((width-c.textWidth(msg))/2, (area.Min.Y+area.Max.Y-lineHeight)/2, msg, fgColor)
+		return c.img
+	}
+
+	if areaMode != "none" {
+		minY = math.Min(minY, 0)
+		maxY = math.Max(maxY, 0)
+	}
+	if v := getFloat64(params, "yMin", math.NaN()); !math.IsNaN(v) {
+		minY = v
+	}
+	if v := getFloat64(params, "yMax", math.NaN()); !math.IsNaN(v) {
+		maxY = v
+	}
+	if maxY <= minY {
+		maxY = minY + 1
+	}
+	if maxX == minX {
+		maxX = minX + 1
+	}
+
+	yStep := niceStep(maxY-minY, 5)
+	minY = math.Floor(minY/yStep) * yStep
+	maxY = math.Ceil(maxY/yStep) * yStep
+
+	if !hideAxes {
+		labelWidth := 0
+		for v := minY; v <= maxY+yStep/2; v += yStep {
+			if w := c.textWidth(formatAxisValue(v, yStep)); w > labelWidth {
+				labelWidth = w
+			}
+		}
+		area.Min.X += labelWidth + gap
+		area.Max.Y -= lineHeight + gap
+	}
+
+	if area.Dx() < 2 || area.Dy() < 2 {
+		return c.img
+	}
+
+	toX := func(t float64) float64 {
+		return float64(area.Min.X) + (t-float64(minX))/float64(maxX-minX)*float64(area.Dx()-1)
+	}
+	toY := func(v float64) float64 {
+		return float64(area.Max.Y-1) - (v-minY)/(maxY-minY)*float64(area.Dy()-1)
+	}
+
+	// y grid and labels
+	for v := minY; v <= maxY+yStep/2; v += yStep {
+		y := int(math.Round(toY(v)))
+		if !hideGrid {
+			c.fillRect(image.Rect(area.Min.X, y, area.Max.X, y+1), gridColor, 0.5)
+		}
+		if !hideAxes {
+			label := formatAxisValue(v, yStep)
+			c.text(area.Min.X-gap-c.textWidth(label), y-lineHeight/2, label, fgColor)
+		}
+	}
+
+	// x grid and labels
+	timeFormat := "15:04"
+	if maxX-minX > 3*86400 {
+		timeFormat = "01/02"
+	} else if maxX-minX > 86400 {
+		timeFormat = "01/02 15:04"
+	}
+	labelCount := area.Dx() / (c.textWidth(timeFormat) + 2*gap)
+	xStep := timeSteps[len(timeSteps)-1]
+	for _, s := range timeSteps {
+		if (maxX-minX)/s <= int64(labelCount) {
+			xStep = s
+			break
+		}
+	}
+	_, offset := time.Unix(minX, 0).In(tz).Zone()
+	for t := ((minX+int64(offset))/xStep+1)*xStep - int64(offset); t <= maxX; t += xStep {
+		x := int(math.Round(toX(float64(t))))
+		if !hideGrid {
+			c.fillRect(image.Rect(x, area.Min.Y, x+1, area.Max.Y), gridColor, 0.5)
+		}
+		if !hideAxes {
+			label := time.Unix(t, 0).In(tz).Format(timeFormat)
+			c.text(x-c.textWidth(label)/2, area.Max.Y+gap, label, fgColor)
+		}
+	}
+
+	// series
+	baseY := toY(math.Max(minY, math.Min(maxY, 0)))
+	for n, r := range drawValues {
+		clr := colors[n]
+		style := styles[n]
+		fill := areaMode == "all" || stacked || (areaMode == "first" && n == 0)
+
+		if style.drawAsInfinite {
+			// vertical band over every step with positive value
+			alpha := style.alpha
+			if alpha <= 0 {
+				alpha = 1
+			}
+			stepWidth := toX(float64(minX+r.StepTime)) - toX(float64(minX))
+			for i, v := range r.Values {
+				if !(v > 0) {
+					continue
+				}
+				x := toX(float64(r.StartTime + int64(i)*r.StepTime))
+				left := int(math.Round(x - stepWidth/2))
+				right := int(math.Max(float64(left+1), math.Round(x+stepWidth/2)))
+				c.fillRect(image.Rect(left, area.Min.Y, right, area.Max.Y), clr, alpha)
+			}
+			continue
+		}
+
+		dash := style.dashed * pixelRatio
+		pathLength := 0.0
+		prevX, prevY := math.NaN(), math.NaN()
+		for i, v := range r.Values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				prevX = math.NaN()
+				continue
+			}
+			x := toX(float64(r.StartTime + int64(i)*r.StepTime))
+			y := toY(math.Max(minY, math.Min(maxY, v)))
+
+			if fill && !math.IsNaN(prevX) {
+				for px := int(math.Round(prevX)); px < int(math.Round(x)); px++ {
+					py := prevY + (y-prevY)*(float64(px)-prevX)/(x-prevX)
+					bottom := baseY
+					if stackedOn[n] >= 0 {
+						prev := valueAt(drawValues[stackedOn[n]], r.StartTime+int64(i)*r.StepTime)
+						if !math.IsNaN(prev) {
+							bottom = toY(math.Max(minY, math.Min(maxY, prev)))
+						}
+					}
+					top, bot := int(math.Round(math.Min(py, bottom))), int(math.Round(math.Max(py, bottom)))
+					c.fillRect(image.Rect(px, top, px+1, bot), clr, areaAlpha)
+				}
+			}
+
+			if math.IsNaN(prevX) {
+				pathLength = c.dashedLine(x, y, x, y, lineWidth, clr, dash, pathLength)
+			} else {
+				pathLength = c.dashedLine(prevX, prevY, x, y, lineWidth, clr, dash, pathLength)
+			}
+			prevX, prevY = x, y
+		}
+	}
+
+	return c.img
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"math"
+	"net/url"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoRenderer(t *testing.T) {
+	assert := assert.New(t)
+
+	values := make([]float64, 120)
+	for i := range values {
+		values[i] = 50 + 40*math.Sin(float64(i)/15)
+	}
+	results := []*types.MetricData{
+		types.MakeMetricData("sin", values, 60, 1537555200),
+		types.MakeMetricData("const", []float64{20, 20, math.NaN(), 30}, 1800, 1537555200),
+	}
+
+	r, err := GetRenderer("go")
+	assert.NoError(err)
+
+	params := url.Values{"width": {"400"}, "height": {"200"}, "pixelRatio": {"2"}, "title": {"Test"}, "areaMode": {"all"}, "areaAlpha": {"0.3"}, "bgcolor": {"ffffff"}, "fgcolor": {"black"}}
+	body, err := r.Render(params, results, "png")
+	assert.NoError(err)
+
+	img, err := png.Decode(bytes.NewReader(body))
+	assert.NoError(err)
+	assert.Equal(800, img.Bounds().Dx())
+	assert.Equal(400, img.Bounds().Dy())
+
+	bg, _, _, _ := img.At(0, 0).RGBA()
+	assert.Equal(uint32(0xffff), bg)
+
+	_, err = r.Render(params, results, "svg")
+	assert.Error(err)
+
+	_, err = GetRenderer("unknown")
+	assert.Error(err)
+}
+
+func TestGoRendererSeriesStyle(t *testing.T) {
+	assert := assert.New(t)
+
+	firing := make([]float64, 10)
+	for i := range firing {
+		firing[i] = math.NaN()
+		if i >= 5 {
+			firing[i] = 1
+		}
+	}
+	results := []*types.MetricData{
+		types.MakeMetricData("value", []float64{10, 10, 10, 10, 10, 10, 10, 10, 10, 10}, 60, 1537555200),
+		thresholdSeries(constantSeries("threshold", 20, 1537555200, 1537555740, 60)),
+		firingSeries(types.MakeMetricData("firing", firing, 60, 1537555200)),
+	}
+	defer releaseSeriesStyles(results)
+
+	img := drawGoGraph(url.Values{"width": {"200"}, "height": {"100"}, "graphOnly": {"true"}, "hideGrid": {"true"}, "bgcolor": {"white"}}, results)
+
+	// firing band is red with alpha 0.2, points before alert start are not drawn
+	assert.Equal(color.RGBA{244, 204, 214, 255}, img.RGBAAt(150, 50))
+	assert.Equal(color.RGBA{255, 255, 255, 255}, img.RGBAAt(50, 50))
+
+	// threshold is red dashed line on top of area
+	red, _ := parseColor("red")
+	var dash, gap int
+	for x := 10; x < 100; x++ {
+		switch img.RGBAAt(x, 10) {
+		case red:
+			dash++
+		case color.RGBA{255, 255, 255, 255}:
+			gap++
+		}
+	}
+	assert.True(dash > 20, "dash pixels: %d", dash)
+	assert.True(gap > 20, "gap pixels: %d", gap)
+}
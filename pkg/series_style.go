@@ -0,0 +1,10 @@
+package pkg
+
+// seriesStyle is per-series graph options from carbonapi types.GraphOptions used by go renderer
+type seriesStyle struct {
+	color          string
+	dashed         float64
+	drawAsInfinite bool
+	// 0 - opaque
+	alpha float64
+}
@@ -0,0 +1,26 @@
+//go:build cairo
+// +build cairo
+
+package pkg
+
+import "github.com/go-graphite/carbonapi/expr/types"
+
+func setSeriesStyle(md *types.MetricData, s seriesStyle) {
+	md.Color = s.color
+	md.Dashed = s.dashed
+	md.DrawAsInfinite = s.drawAsInfinite
+	if s.alpha > 0 {
+		md.Alpha = s.alpha
+		md.HasAlpha = true
+	}
+}
+
+func getSeriesStyle(md *types.MetricData) seriesStyle {
+	s := seriesStyle{color: md.Color, dashed: md.Dashed, drawAsInfinite: md.DrawAsInfinite}
+	if md.HasAlpha {
+		s.alpha = md.Alpha
+	}
+	return s
+}
+
+func releaseSeriesStyles(results []*types.MetricData) {}
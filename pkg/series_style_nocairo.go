@@ -0,0 +1,36 @@
+//go:build !cairo
+// +build !cairo
+
+package pkg
+
+import (
+	"sync"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+)
+
+// types.GraphOptions is empty without cairo, styles are kept aside until releaseSeriesStyles
+var seriesStyles = struct {
+	sync.Mutex
+	m map[*types.MetricData]seriesStyle
+}{m: make(map[*types.MetricData]seriesStyle)}
+
+func setSeriesStyle(md *types.MetricData, s seriesStyle) {
+	seriesStyles.Lock()
+	seriesStyles.m[md] = s
+	seriesStyles.Unlock()
+}
+
+func getSeriesStyle(md *types.MetricData) seriesStyle {
+	seriesStyles.Lock()
+	defer seriesStyles.Unlock()
+	return seriesStyles.m[md]
+}
+
+func releaseSeriesStyles(results []*types.MetricData) {
+	seriesStyles.Lock()
+	for _, md := range results {
+		delete(seriesStyles.m, md)
+	}
+	seriesStyles.Unlock()
+}
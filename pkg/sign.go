@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	errSignatureRequired = errors.New("signature required")
+	errSignatureInvalid  = errors.New("invalid signature")
+	errSignatureExpired  = errors.New("signature expired")
+)
+
+// Signer signs request parameters with HMAC-SHA256. Signature is sig parameter,
+// optional exp parameter is unix time of expiration
+type Signer struct {
+	secret  []byte
+	require bool
+}
+
+// NewSigner creates signer. If require is true unsigned requests are rejected
+func NewSigner(secret string, require bool) *Signer {
+	return &Signer{secret: []byte(secret), require: require}
+}
+
+func (s *Signer) signature(values url.Values) string {
+	v := url.Values{}
+	for k, vv := range values {
+		if k != "sig" {
+			v[k] = vv
+		}
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	// Encode sorts by key
+	mac.Write([]byte(v.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign adds exp (if ttl > 0) and sig parameters
+func (s *Signer) Sign(values url.Values, ttl time.Duration) {
+	values.Del("exp")
+	if ttl > 0 {
+		values.Set("exp", strconv.FormatInt(timeNow().Add(ttl).Unix(), 10))
+	}
+	values.Set("sig", s.signature(values))
+}
+
+// Verify checks signature and expiration. Unsigned request is valid if signature is not required
+func (s *Signer) Verify(values url.Values) error {
+	sig := values.Get("sig")
+	if sig == "" {
+		if s.require {
+			return errSignatureRequired
+		}
+		return nil
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signature(values))) {
+		return errSignatureInvalid
+	}
+	if exp := values.Get("exp"); exp != "" {
+		t, err := strconv.ParseInt(exp, 10, 64)
+		if err != nil {
+			return errSignatureInvalid
+		}
+		if timeNow().Unix() > t {
+			return errSignatureExpired
+		}
+	}
+	return nil
+}
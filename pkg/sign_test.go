@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigner(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSigner("secret", true)
+
+	values := url.Values{"g0.expr": {"up"}, "from": {"-1h"}}
+	s.Sign(values, time.Hour)
+	assert.NotEmpty(values.Get("exp"))
+	assert.NoError(s.Verify(values))
+
+	values.Set("g0.expr", "sum(up)")
+	assert.Equal(errSignatureInvalid, s.Verify(values))
+
+	assert.Equal(errSignatureRequired, s.Verify(url.Values{"g0.expr": {"up"}}))
+	assert.NoError(NewSigner("secret", false).Verify(url.Values{"g0.expr": {"up"}}))
+
+	values = url.Values{"g0.expr": {"up"}}
+	s.Sign(values, time.Hour)
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	assert.Equal(errSignatureExpired, s.Verify(values))
+}
+
+func TestSignedRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t)
+	defer prom.Close()
+
+	s := NewSigner("secret", true)
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetSigner(s)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&format=json", nil))
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Len(prom.queries(), 0)
+
+	values := url.Values{"g0.expr": {"up"}, "format": {"json"}}
+	s.Sign(values, 0)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?"+values.Encode(), nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Len(prom.queries(), 1)
+
+	values = url.Values{"url": {"http://prom/graph?g0.expr=up&g0.range_input=1h"}, "format": {"json"}}
+	s.Sign(values, time.Hour)
+	w = httptest.NewRecorder()
+	NewFromPrometheus(h).ServeHTTP(w, httptest.NewRequest("GET", "/from-prometheus?"+values.Encode(), nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Len(prom.queries(), 2)
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Snappy block format (https://github.com/google/snappy/blob/main/format_description.txt)
+// used by prometheus remote read. Encoder emits literals only, it is valid but not compressed stream
+
+var errSnappyCorrupt = errors.New("snappy: corrupt input")
+
+func snappyEncode(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(src)+len(src)/65536*3+5)
+	dst = dst[:binary.PutUvarint(dst, uint64(len(src)))]
+
+	for len(src) > 0 {
+		n := len(src)
+		if n > 65536 {
+			n = 65536
+		}
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 256:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
+
+// snappyDecode decodes block of declared length up to maxLen bytes
+func snappyDecode(src []byte, maxLen int) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errSnappyCorrupt
+	}
+	if length > uint64(maxLen) {
+		return nil, fmt.Errorf("snappy: decoded length %d exceeds limit %d", length, maxLen)
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var litLen, copyLen, offset int
+		switch tag & 3 {
+		case 0:
+			litLen = int(tag >> 2)
+			src = src[1:]
+			if litLen >= 60 {
+				extra := litLen - 59
+				if len(src) < extra {
+					return nil, errSnappyCorrupt
+				}
+				litLen = 0
+				for i := extra - 1; i >= 0; i-- {
+					litLen = litLen<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			litLen++
+			if litLen > len(src) || uint64(len(dst)+litLen) > length {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:litLen]...)
+			src = src[litLen:]
+			continue
+		case 1:
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			copyLen = 4 + int(tag>>2)&7
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2:
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			copyLen = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:3]))
+			src = src[3:]
+		case 3:
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			copyLen = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:5]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+copyLen) > length {
+			return nil, errSnappyCorrupt
+		}
+		// copy may overlap with its own output
+		pos := len(dst) - offset
+		for i := 0; i < copyLen; i++ {
+			dst = append(dst, dst[pos+i])
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,141 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Static returns series from data in expression: inline CSV "timestamp,value" lines separated by
+// newline or ";", JSON array of [timestamp, value] pairs or "file:<name>" with same content in dir.
+// Points are averaged to query step, steps between points are linearly interpolated. Null value breaks the line
+type Static struct {
+	dir string
+}
+
+// NewStatic creates static datasource. Empty dir disables files
+func NewStatic(dir string) *Static {
+	return &Static{dir: dir}
+}
+
+type staticPoint struct {
+	ts    float64
+	value float64
+}
+
+func parseStaticData(data string) ([]staticPoint, error) {
+	data = strings.TrimSpace(data)
+
+	if strings.HasPrefix(data, "[") {
+		var pairs [][2]*float64
+		if err := json.Unmarshal([]byte(data), &pairs); err != nil {
+			return nil, err
+		}
+		points := make([]staticPoint, 0, len(pairs))
+		for _, p := range pairs {
+			if p[0] == nil {
+				return nil, fmt.Errorf("null timestamp")
+			}
+			v := math.NaN()
+			if p[1] != nil {
+				v = *p[1]
+			}
+			points = append(points, staticPoint{*p[0], v})
+		}
+		return points, nil
+	}
+
+	lines := strings.FieldsFunc(data, func(c rune) bool { return c == '\n' || c == ';' })
+	points := make([]staticPoint, 0, len(lines))
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected timestamp,value", i+1)
+		}
+		ts, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp %#v", i+1, fields[0])
+		}
+		v := math.NaN()
+		if s := strings.TrimSpace(fields[1]); s != "" && s != "null" {
+			if v, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid value %#v", i+1, fields[1])
+			}
+		}
+		points = append(points, staticPoint{ts, v})
+	}
+	return points, nil
+}
+
+// staticFile returns file name of "file:<name>" expression, empty string for inline data
+func staticFile(expr string) string {
+	if strings.HasPrefix(expr, "file:") {
+		return strings.TrimPrefix(expr, "file:")
+	}
+	return ""
+}
+
+func (s *Static) QueryRange(ctx context.Context, expr string, start, end, step int64) ([]*Series, error) {
+	name := "data"
+	data := expr
+
+	if filename := staticFile(expr); filename != "" {
+		if s.dir == "" {
+			return nil, fmt.Errorf("static files are disabled")
+		}
+		if filename != filepath.Base(filename) || filename == "." || filename == ".." {
+			return nil, fmt.Errorf("invalid static file name %#v", filename)
+		}
+		body, err := ioutil.ReadFile(filepath.Join(s.dir, filename))
+		if err != nil {
+			return nil, err
+		}
+		name = strings.TrimSuffix(filename, filepath.Ext(filename))
+		data = string(body)
+	}
+
+	points, err := parseStaticData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(points, func(i, j int) bool { return points[i].ts < points[j].ts })
+
+	c := newConsolidatedSeries(start, end, step)
+	for _, p := range points {
+		c.add(p.ts, p.value)
+	}
+
+	// interpolate empty steps between points
+	next := 0
+	for i := range c.count {
+		t := float64(start + int64(i)*step)
+		for next < len(points) && points[next].ts <= t {
+			next++
+		}
+		if c.count[i] > 0 || next == 0 || next == len(points) {
+			continue
+		}
+		p, n := points[next-1], points[next]
+		if math.IsNaN(p.value) || math.IsNaN(n.value) {
+			continue
+		}
+		c.sum[i] = p.value + (n.value-p.value)*(t-p.ts)/(n.ts-p.ts)
+		c.count[i] = 1
+	}
+	series := c.series(map[string]string{"__name__": name})
+	if series == nil {
+		return nil, nil
+	}
+	return []*Series{series}, nil
+}
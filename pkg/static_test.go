@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStaticData(t *testing.T) {
+	assert := assert.New(t)
+
+	points, err := parseStaticData("100,1;160,2\n220,\n")
+	assert.NoError(err)
+	if assert.Len(points, 3) {
+		assert.Equal(staticPoint{160, 2}, points[1])
+		assert.True(math.IsNaN(points[2].value))
+	}
+
+	points, err = parseStaticData(`[[100, 1], [160, null]]`)
+	assert.NoError(err)
+	if assert.Len(points, 2) {
+		assert.Equal(staticPoint{100, 1}, points[0])
+		assert.True(math.IsNaN(points[1].value))
+	}
+
+	_, err = parseStaticData("100;1")
+	assert.Error(err)
+	_, err = parseStaticData("x,1")
+	assert.Error(err)
+}
+
+func TestStatic(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "static")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "plan.csv"), []byte("1000,10\n1240,70\n"), 0644))
+
+	s := NewStatic(dir)
+	result, err := s.QueryRange(context.Background(), "file:plan.csv", 940, 1300, 60)
+	assert.NoError(err)
+	if assert.Len(result, 1) {
+		assert.Equal("plan", result[0].Labels["__name__"])
+		assert.Equal(int64(1000), result[0].StartTime)
+		assert.Equal([]float64{10, 25, 40, 55, 70}, result[0].Values)
+	}
+
+	// null breaks interpolation
+	result, err = s.QueryRange(context.Background(), "1000,10;1120,;1240,70", 1000, 1240, 60)
+	assert.NoError(err)
+	if assert.Len(result, 1) {
+		v := result[0].Values
+		assert.Equal(10.0, v[0])
+		assert.True(math.IsNaN(v[1]))
+		assert.Equal(70.0, v[4])
+	}
+
+	_, err = s.QueryRange(context.Background(), "file:../plan.csv", 940, 1300, 60)
+	assert.Error(err)
+	_, err = NewStatic("").QueryRange(context.Background(), "file:plan.csv", 940, 1300, 60)
+	assert.Error(err)
+}
+
+func TestStaticData(t *testing.T) {
+	assert := assert.New(t)
+
+	h := NewPNG("http://127.0.0.1:1", "/api/v1/query_range", time.Second)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.data=1537555344,1%3B1537555404,3&g0.legend=plan&from=1537555344&until=1537594944&format=csv", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"plan",`)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.data=bad&format=csv", nil))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.data=1,1&g0.expr=up&format=csv", nil))
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+)
+
+// Status serves health, readiness and build info endpoints
+type Status struct {
+	png      *Handler
+	version  string
+	revision string
+}
+
+func NewStatus(png *Handler, version string, revision string) *Status {
+	return &Status{
+		png:      png,
+		version:  version,
+		revision: revision,
+	}
+}
+
+func (s *Status) Healthy(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "Healthy.\n")
+}
+
+func (s *Status) checkRenderer() error {
+	body, err := s.png.renderer.Render(
+		url.Values{"width": {"1"}, "height": {"1"}, "graphOnly": {"true"}},
+		[]*types.MetricData{types.MakeMetricData("ready", []float64{1, 2}, 1, 0)},
+		"png",
+	)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return fmt.Errorf("renderer returned empty picture")
+	}
+	return nil
+}
+
+func (s *Status) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.png.defaultTimeout)
+	defer cancel()
+
+	if err := s.png.Ping(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := s.checkRenderer(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintf(w, "Ready.\n")
+}
+
+// BuildInfo is compatible with prometheus /api/v1/status/buildinfo
+func (s *Status) BuildInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data": map[string]string{
+			"version":   s.version,
+			"revision":  s.revision,
+			"goVersion": runtime.Version(),
+		},
+	})
+}
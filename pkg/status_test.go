@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusReady(t *testing.T) {
+	assert := assert.New(t)
+
+	ready := true
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/-/ready", r.URL.Path)
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer prom.Close()
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetRenderer(renderers["go"])
+	status := NewStatus(h, "1.0", "abc")
+
+	w := httptest.NewRecorder()
+	status.Ready(w, httptest.NewRequest("GET", "/-/ready", nil))
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	ready = false
+	w = httptest.NewRecorder()
+	status.Ready(w, httptest.NewRequest("GET", "/-/ready", nil))
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	status.BuildInfo(w, httptest.NewRequest("GET", "/api/v1/status/buildinfo", nil))
+	assert.Contains(w.Body.String(), `"version":"1.0"`)
+}
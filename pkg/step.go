@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default scrape interval for $__rate_interval if datasource has no min step
+const defaultScrapeInterval = 15
+
+// max points per series, same as prometheus query_range limit
+const maxPoints = 11000
+
+// parseStep parses step in seconds ("30", "0.5") or prometheus duration ("1m", "1h30m"). Result is at least 1 second
+func parseStep(s string) (int64, error) {
+	var step int64
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f < 0 || f > math.MaxInt32 || math.IsNaN(f) {
+			return 0, fmt.Errorf("invalid step %#v", s)
+		}
+		step = int64(math.Ceil(f))
+	} else {
+		d, err := parsePromDuration(s)
+		if err != nil {
+			return 0, err
+		}
+		step = int64((d + time.Second - 1) / time.Second)
+	}
+	if step < 1 {
+		step = 1
+	}
+	return step, nil
+}
+
+// parseResolution parses fraction of points per pixel like "1/2" or "0.5" in range (0, 1]
+func parseResolution(s string) (float64, error) {
+	var r float64
+	var err error
+	if i := strings.Index(s, "/"); i >= 0 {
+		var n, d float64
+		if n, err = strconv.ParseFloat(s[:i], 64); err == nil {
+			d, err = strconv.ParseFloat(s[i+1:], 64)
+			r = n / d
+		}
+	} else {
+		r, err = strconv.ParseFloat(s, 64)
+	}
+	if err != nil || !(r > 0 && r <= 1) {
+		return 0, fmt.Errorf("resolution should be fraction in range (0, 1] like 1/2, got %#v", s)
+	}
+	return r, nil
+}
+
+// interpolateIntervals replaces $__interval and $__rate_interval in expression like grafana does.
+// $__rate_interval is max($__interval + scrape interval, 4 * scrape interval)
+func interpolateIntervals(expr string, step int64, scrapeInterval int64) string {
+	if !strings.Contains(expr, "$__") {
+		return expr
+	}
+	rateInterval := step + scrapeInterval
+	if rateInterval < 4*scrapeInterval {
+		rateInterval = 4 * scrapeInterval
+	}
+	return strings.NewReplacer(
+		"$__rate_interval", fmt.Sprintf("%ds", rateInterval),
+		"$__interval", fmt.Sprintf("%ds", step),
+	).Replace(expr)
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStep(t *testing.T) {
+	assert := assert.New(t)
+
+	for s, expected := range map[string]int64{"30": 30, "0.5": 1, "0": 1, "1m": 60, "1h30m": 5400, "500ms": 1} {
+		step, err := parseStep(s)
+		assert.NoError(err, s)
+		assert.Equal(expected, step, s)
+	}
+	for _, s := range []string{"", "-1", "1x", "NaN", "+Inf", "1e30"} {
+		_, err := parseStep(s)
+		assert.Error(err, s)
+	}
+
+	for s, expected := range map[string]float64{"1/2": 0.5, "1/1": 1, "0.25": 0.25} {
+		r, err := parseResolution(s)
+		assert.NoError(err, s)
+		assert.Equal(expected, r, s)
+	}
+	for _, s := range []string{"2", "0", "1/0", "a/2", "-1/2"} {
+		_, err := parseResolution(s)
+		assert.Error(err, s)
+	}
+}
+
+func TestInterpolateIntervals(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("rate(x[60s]) / 30s", interpolateIntervals("rate(x[$__rate_interval]) / $__interval", 30, 15))
+	assert.Equal("rate(x[135s])", interpolateIntervals("rate(x[$__rate_interval])", 120, 15))
+	assert.Equal("up", interpolateIntervals("up", 120, 15))
+}
+
+func TestStepParams(t *testing.T) {
+	assert := assert.New(t)
+
+	prom := fakePrometheus(t)
+	defer prom.Close()
+
+	h := NewPNG(prom.URL, "/api/v1/query_range", time.Second)
+	h.SetDatasource("slow", NewPrometheus(prom.URL, "/api/v1/query_range"))
+	h.SetMinStep("slow", 5*time.Minute)
+
+	// range 39600, width 330: automatic step is 60
+	const timeRange = "&from=1537555344&until=1537594944&format=csv"
+
+	tests := []struct {
+		query string
+		step  string
+		start string
+		expr  string
+	}{
+		{"g0.expr=up", "60", "1537555320", "up"},
+		{"g0.expr=up&resolution=1/2", "120", "1537555320", "up"},
+		{"g0.expr=up&step=1m30s", "90", "1537555320", "up"},
+		{"g0.expr=up&minStep=100", "100", "1537555300", "up"},
+		{"g0.expr=rate(x[$__rate_interval])&g0.ds=slow", "300", "1537555200", "rate(x[1200s])"},
+		{"g0.expr=rate(x[$__interval])&step=30", "30", "1537555320", "rate(x[30s])"},
+	}
+
+	for _, test := range tests {
+		n := len(prom.queries())
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/?"+test.query+timeRange, nil))
+		assert.Equal(http.StatusOK, w.Code, test.query)
+		if queries := prom.queries()[n:]; assert.Len(queries, 1, test.query) {
+			assert.Equal(test.step, queries[0].Get("step"), test.query)
+			assert.Equal(test.start, queries[0].Get("start"), test.query)
+			assert.Equal(test.expr, queries[0].Get("query"), test.query)
+		}
+	}
+
+	for _, query := range []string{
+		"g0.expr=up&resolution=2" + timeRange,
+		"g0.data=1,1&step=1&from=0&until=20000000&format=csv",
+		"g0.expr=up&width=100000&from=0&until=20000000&format=csv",
+		"g0.expr=up&from=1537594944&until=1537555344&format=csv",
+	} {
+		n := len(prom.queries())
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/?"+query, nil))
+		assert.Equal(http.StatusBadRequest, w.Code, query)
+		assert.Len(prom.queries()[n:], 0, query)
+	}
+
+	// the limit is checked with datasource min step
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g0.ds=slow&step=1&from=0&until=3000000&format=csv", nil))
+	assert.Equal(http.StatusOK, w.Code)
+}
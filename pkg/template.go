@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+var templatesMutex sync.RWMutex
+var templates = map[string]url.Values{}
+
+// SetTemplate registers named set of picture parameters. All templates inherit "default"
+func SetTemplate(name string, values url.Values) {
+	templatesMutex.Lock()
+	templates[name] = values
+	templatesMutex.Unlock()
+}
+
+// SetTemplates replaces all templates at once
+func SetTemplates(values map[string]url.Values) {
+	templatesMutex.Lock()
+	templates = values
+	templatesMutex.Unlock()
+}
+
+// pictureValues merges default template, named template and request parameters
+func pictureValues(templateName string, request url.Values) url.Values {
+	values := url.Values{}
+
+	templatesMutex.RLock()
+	for k, v := range templates["default"] {
+		values[k] = v
+	}
+	if templateName != "default" {
+		for k, v := range templates[templateName] {
+			values[k] = v
+		}
+	}
+	templatesMutex.RUnlock()
+
+	for k, v := range request {
+		values[k] = v
+	}
+	return values
+}
+
+func templateExists(name string) bool {
+	templatesMutex.RLock()
+	_, exists := templates[name]
+	templatesMutex.RUnlock()
+	return exists
+}
+
+// TemplatesHandler returns resolved parameters of all templates as JSON
+func TemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	templatesMutex.RLock()
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	templatesMutex.RUnlock()
+
+	data := make(map[string]map[string]string, len(names))
+	for _, name := range names {
+		values := pictureValues(name, nil)
+		params := make(map[string]string, len(values))
+		for k := range values {
+			params[k] = values.Get(k)
+		}
+		data[name] = params
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
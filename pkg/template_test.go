@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplatesHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	defer SetTemplates(map[string]url.Values{})
+	SetTemplates(map[string]url.Values{
+		"default": {"bgcolor": {"black"}, "width": {"330"}},
+		"light":   {"bgcolor": {"white"}},
+	})
+
+	w := httptest.NewRecorder()
+	TemplatesHandler(w, httptest.NewRequest("GET", "/api/v1/templates", nil))
+
+	var res struct {
+		Data map[string]map[string]string `json:"data"`
+	}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(map[string]string{"bgcolor": "white", "width": "330"}, res.Data["light"])
+	assert.Equal("black", res.Data["default"]["bgcolor"])
+}
+
+func TestConfigure(t *testing.T) {
+	assert := assert.New(t)
+
+	h := NewPNG("http://127.0.0.1:1", "/api/v1/query_range", time.Second)
+	h.SetDatasource("old", NewStatic(""))
+
+	policy, err := NewPolicy(PolicyOptions{MetricAllow: []string{"up"}})
+	assert.NoError(err)
+
+	defer SetTemplates(map[string]url.Values{})
+	h.Configure(HandlerOptions{
+		Policy:      policy,
+		Datasources: map[string]Datasource{"other": NewStatic("")},
+		MinSteps:    map[string]time.Duration{"other": time.Minute},
+		Templates:   map[string]url.Values{"light": {"bgcolor": {"white"}}},
+	})
+
+	state := h.getState()
+	assert.Equal(policy, state.policy)
+	assert.Contains(state.datasources, "default")
+	assert.Contains(state.datasources, "static")
+	assert.Contains(state.datasources, "other")
+	assert.NotContains(state.datasources, "old")
+	assert.Equal(int64(60), state.minSteps["other"])
+	assert.True(templateExists("light"))
+
+	// request is checked with new state
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=node_load1&from=-1h&until=now", nil))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?g0.expr=up&g0.ds=old&from=-1h&until=now", nil))
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,240 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+)
+
+// TextParams describes text chart. Width and Height are in characters
+type TextParams struct {
+	Width     int
+	Height    int
+	Title     string
+	ColorList []string
+	Tz        *time.Location
+	ANSI      bool
+}
+
+// braille dot bits by [row][column] inside 2x4 character cell
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+func ansiColor(name string) string {
+	c, ok := parseColor(name)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", c.R, c.G, c.B)
+}
+
+const ansiReset = "\x1b[0m"
+
+func formatTextValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+func padLeft(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return strings.Repeat(" ", width-n) + s
+	}
+	return s
+}
+
+// MarshalText draws series as unicode braille chart with legend and axis labels
+func MarshalText(params TextParams, results []*types.MetricData) []byte {
+	var b bytes.Buffer
+
+	if params.Title != "" {
+		b.WriteString(params.Title)
+		b.WriteByte('\n')
+	}
+
+	minX, maxX := int64(math.MaxInt64), int64(math.MinInt64)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, r := range results {
+		for i, v := range r.Values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			t := r.StartTime + int64(i)*r.StepTime
+			if t < minX {
+				minX = t
+			}
+			if t > maxX {
+				maxX = t
+			}
+			minY = math.Min(minY, v)
+			maxY = math.Max(maxY, v)
+		}
+	}
+
+	if minX > maxX {
+		b.WriteString("No Data\n")
+		return b.Bytes()
+	}
+	if maxX == minX {
+		maxX = minX + 1
+	}
+	if maxY == minY {
+		minY, maxY = minY-1, maxY+1
+	}
+
+	yLabels := []string{formatTextValue(maxY), formatTextValue((maxY + minY) / 2), formatTextValue(minY)}
+	labelWidth := 0
+	for _, l := range yLabels {
+		if len(l) > labelWidth {
+			labelWidth = len(l)
+		}
+	}
+
+	plotWidth := params.Width - labelWidth - 1
+	if plotWidth < 2 {
+		plotWidth = 2
+	}
+	plotHeight := params.Height - 2 - len(results)
+	if params.Title != "" {
+		plotHeight--
+	}
+	if plotHeight < 2 {
+		plotHeight = 2
+	}
+
+	dotsX, dotsY := plotWidth*2, plotHeight*4
+	cells := make([][]rune, plotHeight)
+	cellColor := make([][]int, plotHeight)
+	for i := range cells {
+		cells[i] = make([]rune, plotWidth)
+		cellColor[i] = make([]int, plotWidth)
+	}
+
+	set := func(x, y, series int) {
+		if x < 0 || x >= dotsX || y < 0 || y >= dotsY {
+			return
+		}
+		row := (dotsY - 1 - y) / 4
+		col := x / 2
+		cells[row][col] |= brailleDots[(dotsY-1-y)%4][x%2]
+		cellColor[row][col] = series
+	}
+
+	for n, r := range results {
+		prevX, prevY := -1, -1
+		for i, v := range r.Values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				prevX = -1
+				continue
+			}
+			t := r.StartTime + int64(i)*r.StepTime
+			x := int(math.Round(float64(t-minX) / float64(maxX-minX) * float64(dotsX-1)))
+			y := int(math.Round((v - minY) / (maxY - minY) * float64(dotsY-1)))
+			if prevX < 0 {
+				set(x, y, n)
+			} else {
+				// bresenham line from previous point
+				dx, dy := x-prevX, y-prevY
+				steps := dx
+				if steps < 0 {
+					steps = -steps
+				}
+				if dy > steps {
+					steps = dy
+				} else if -dy > steps {
+					steps = -dy
+				}
+				for s := 1; s <= steps; s++ {
+					set(prevX+dx*s/steps, prevY+dy*s/steps, n)
+				}
+				set(x, y, n)
+			}
+			prevX, prevY = x, y
+		}
+	}
+
+	colors := make([]string, len(results))
+	if params.ANSI {
+		for i := range results {
+			if len(params.ColorList) > 0 {
+				colors[i] = ansiColor(params.ColorList[i%len(params.ColorList)])
+			}
+		}
+	}
+
+	for row := 0; row < plotHeight; row++ {
+		label := ""
+		switch row {
+		case 0:
+			label = yLabels[0]
+		case plotHeight / 2:
+			label = yLabels[1]
+		case plotHeight - 1:
+			label = yLabels[2]
+		}
+		b.WriteString(padLeft(label, labelWidth))
+		b.WriteString("┤")
+		current := ""
+		for col := 0; col < plotWidth; col++ {
+			c := cells[row][col]
+			if c != 0 && params.ANSI {
+				if color := colors[cellColor[row][col]]; color != current {
+					if color == "" {
+						b.WriteString(ansiReset)
+					} else {
+						b.WriteString(color)
+					}
+					current = color
+				}
+			}
+			b.WriteRune(0x2800 + c)
+		}
+		if current != "" {
+			b.WriteString(ansiReset)
+		}
+		b.WriteByte('\n')
+	}
+
+	b.WriteString(strings.Repeat(" ", labelWidth))
+	b.WriteString("└")
+	b.WriteString(strings.Repeat("─", plotWidth))
+	b.WriteByte('\n')
+
+	tz := params.Tz
+	if tz == nil {
+		tz = time.Local
+	}
+	timeFormat := "15:04"
+	if maxX-minX > 24*3600 {
+		timeFormat = "01-02 15:04"
+	}
+	startLabel := time.Unix(minX, 0).In(tz).Format(timeFormat)
+	endLabel := time.Unix(maxX, 0).In(tz).Format(timeFormat)
+	b.WriteString(strings.Repeat(" ", labelWidth+1))
+	b.WriteString(startLabel)
+	b.WriteString(padLeft(endLabel, plotWidth-len(startLabel)))
+	b.WriteByte('\n')
+
+	for i, r := range results {
+		if colors[i] != "" {
+			b.WriteString(colors[i])
+			b.WriteString("⣿")
+			b.WriteString(ansiReset)
+		} else {
+			b.WriteString("⣿")
+		}
+		b.WriteByte(' ')
+		b.WriteString(r.Name)
+		b.WriteByte('\n')
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalText(t *testing.T) {
+	assert := assert.New(t)
+
+	values := make([]float64, 60)
+	for i := range values {
+		values[i] = math.Sin(float64(i) / 10)
+	}
+	values[30] = math.NaN()
+
+	params := TextParams{Width: 40, Height: 12, Title: "sin", ColorList: []string{"red", "00ff00"}, Tz: time.UTC, ANSI: true}
+	body := string(MarshalText(params, []*types.MetricData{
+		types.MakeMetricData("sin", values, 60, 1537555200),
+		types.MakeMetricData("const", []float64{0.5, 0.5}, 3540, 1537555200),
+	}))
+
+	lines := strings.Split(strings.TrimRight(body, "\n"), "\n")
+	assert.Len(lines, 12)
+	assert.Equal("sin", lines[0])
+	assert.True(strings.HasPrefix(strings.TrimSpace(lines[1]), "0.9996┤"))
+	assert.Contains(body, "\x1b[38;2;200;0;50m")
+	assert.Contains(body, "18:40")
+	assert.True(strings.HasSuffix(lines[11], " const"))
+
+	assert.Equal("No Data\n", string(MarshalText(TextParams{Width: 40, Height: 10}, nil)))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lomik/prometheus-png/pkg"
+)
+
+// reloader re-reads config file and swaps templates, datasources, policies, api keys and signer at once.
+// Invalid config is rejected, running config is kept. Other sections require restart
+type reloader struct {
+	mu       sync.Mutex
+	filename string
+	mtime    time.Time
+	handler  *pkg.Handler
+}
+
+func newReloader(filename string, handler *pkg.Handler) *reloader {
+	r := &reloader{filename: filename, handler: handler}
+	if st, err := os.Stat(filename); err == nil {
+		r.mtime = st.ModTime()
+	}
+	return r
+}
+
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.filename == "" {
+		return fmt.Errorf("config file is not set")
+	}
+
+	if st, err := os.Stat(r.filename); err == nil {
+		r.mtime = st.ModTime()
+	}
+
+	config, err := loadConfig(r.filename)
+	if err != nil {
+		return err
+	}
+
+	options, err := config.handlerOptions()
+	if err != nil {
+		return err
+	}
+	r.handler.Configure(options)
+	return nil
+}
+
+func (r *reloader) reload(reason string) {
+	if err := r.Reload(); err != nil {
+		log.Printf("config reload (%s) failed, keeping running config: %s", reason, err)
+		return
+	}
+	log.Printf("config reloaded (%s)", reason)
+}
+
+// Watch reloads config on SIGHUP and, if interval > 0, on config file modification time change
+func (r *reloader) Watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 && r.filename != "" {
+		tick = time.NewTicker(interval).C
+	}
+
+	for {
+		select {
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-tick:
+			st, err := os.Stat(r.filename)
+			if err != nil {
+				continue
+			}
+			r.mu.Lock()
+			changed := !st.ModTime().Equal(r.mtime)
+			r.mu.Unlock()
+			if changed {
+				r.reload("file changed")
+			}
+		}
+	}
+}
+
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.Reload(); err != nil {
+		log.Printf("config reload (%s) failed, keeping running config: %s", req.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("config reloaded (%s)", req.URL.Path)
+	fmt.Fprintf(w, "Reloaded.\n")
+}